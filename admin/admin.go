@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"strings"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 配置
+type AdminServerConfig struct {
+	ListenAddr string                                   // 管理接口监听地址
+	Nsd        *service_discovery.NacosServiceDiscovery // 服务发现
+}
+
+// 管理接口，只读JSON
+type AdminServer struct {
+	server *http.Server
+	config *AdminServerConfig
+}
+
+// 输出JSON
+func writeJson(rw http.ResponseWriter, statusCode int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(statusCode)
+	encoder := json.NewEncoder(rw)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// GET /services：全部缓存服务及节点
+func (adminServer *AdminServer) handleServices(rw http.ResponseWriter, req *http.Request) {
+	writeJson(rw, http.StatusOK, adminServer.config.Nsd.Services())
+}
+
+// GET /services/{name}：单个服务及节点
+func (adminServer *AdminServer) handleService(rw http.ResponseWriter, req *http.Request) {
+	serviceName := strings.TrimPrefix(req.URL.Path, "/services/")
+	if serviceStatus := adminServer.config.Nsd.Service(serviceName); serviceStatus != nil {
+		writeJson(rw, http.StatusOK, serviceStatus)
+	} else {
+		writeJson(rw, http.StatusNotFound, map[string]string{"error": "service not cached"})
+	}
+}
+
+// 节点熔断器状态
+type breakerItem struct {
+	ServiceName string                           `json:"service_name"`
+	ID          string                           `json:"id"`
+	Ip          string                           `json:"ip"`
+	Port        uint64                           `json:"port"`
+	Breaker     *service_discovery.BreakerStatus `json:"breaker"`
+	Health      *service_discovery.HealthStatus  `json:"health"`
+}
+
+// GET /breakers：全部节点的熔断器与探测状态
+func (adminServer *AdminServer) handleBreakers(rw http.ResponseWriter, req *http.Request) {
+	items := make([]*breakerItem, 0)
+	for _, serviceStatus := range adminServer.config.Nsd.Services() {
+		for _, ins := range serviceStatus.Instances {
+			items = append(items, &breakerItem{
+				ServiceName: serviceStatus.ServiceName,
+				ID:          ins.ID,
+				Ip:          ins.Ip,
+				Port:        ins.Port,
+				Breaker:     ins.Breaker,
+				Health:      ins.Health,
+			})
+		}
+	}
+	writeJson(rw, http.StatusOK, items)
+}
+
+// 启动管理接口
+func (adminServer *AdminServer) Run() (err error) {
+	if err = adminServer.server.ListenAndServe(); err == http.ErrServerClosed {
+		err = nil
+	}
+	return
+}
+
+// 新建管理接口
+func NewAdminServer(adminServerConfig *AdminServerConfig) (adminServer *AdminServer, err error) {
+	adminServer = &AdminServer{config: adminServerConfig}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", adminServer.handleServices)
+	mux.HandleFunc("/services/", adminServer.handleService)
+	mux.HandleFunc("/breakers", adminServer.handleBreakers)
+	mux.Handle("/debug/vars", expvar.Handler()) // 指标
+
+	adminServer.server = &http.Server{
+		Addr:    adminServerConfig.ListenAddr,
+		Handler: mux,
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 启动管理接口，nacos不可用，a.yuerblog.cc从磁盘快照恢复
+func newTestAdminServer(t *testing.T) (server *httptest.Server) {
+	dir := t.TempDir()
+	snapshot := `{"service_name": "a.yuerblog.cc", "instances": [
+		{"id": "1", "ip": "10.0.0.1", "port": 80, "weight": 1, "cluster": "main"},
+		{"id": "2", "ip": "10.0.0.2", "port": 80, "weight": 1, "cluster": "main"}
+	]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.yuerblog.cc.json"), []byte(snapshot), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nsd, err := service_discovery.NewNacosServiceDiscovery(&service_discovery.NacosSDConfig{
+		Namespace:   "myns",
+		Cluster:     "main",
+		Group:       "default",
+		NacosNodes:  []service_discovery.NacosNode{{Ip: "127.0.0.1", Port: 1}},
+		SnapshotDir: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 首次访问时拉取失败，从快照恢复
+	ins, err := nsd.SelectInstance(&service_discovery.SelectInstanceOptions{ServiceName: "a.yuerblog.cc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nsd.ReleaseInstance(&service_discovery.MarkInstanceOptions{ServiceName: ins.ServiceName, ID: ins.ID})
+	adminServer, err := NewAdminServer(&AdminServerConfig{Nsd: nsd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(adminServer.server.Handler)
+}
+
+// GET并解析JSON应答
+func getJson(t *testing.T, url string, v interface{}) (statusCode int) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Fatal(resp.Header)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestServices(t *testing.T) {
+	server := newTestAdminServer(t)
+	defer server.Close()
+
+	var services []*service_discovery.ServiceStatus
+	if statusCode := getJson(t, server.URL+"/services", &services); statusCode != http.StatusOK {
+		t.Fatal(statusCode)
+	}
+	if len(services) != 1 || services[0].ServiceName != "a.yuerblog.cc" || !services[0].Stale || len(services[0].Instances) != 2 {
+		t.Fatal(services)
+	}
+
+	// 单个服务
+	var service service_discovery.ServiceStatus
+	if statusCode := getJson(t, server.URL+"/services/a.yuerblog.cc", &service); statusCode != http.StatusOK {
+		t.Fatal(statusCode)
+	}
+	if service.ServiceName != "a.yuerblog.cc" || len(service.Instances) != 2 || service.Instances[0].Breaker == nil || service.Instances[0].Health == nil {
+		t.Fatal(service)
+	}
+
+	// 未缓存的服务
+	var errorBody map[string]string
+	if statusCode := getJson(t, server.URL+"/services/b.yuerblog.cc", &errorBody); statusCode != http.StatusNotFound || errorBody["error"] == "" {
+		t.Fatal(statusCode, errorBody)
+	}
+}
+
+func TestBreakers(t *testing.T) {
+	server := newTestAdminServer(t)
+	defer server.Close()
+
+	var items []*breakerItem
+	if statusCode := getJson(t, server.URL+"/breakers", &items); statusCode != http.StatusOK {
+		t.Fatal(statusCode)
+	}
+	if len(items) != 2 {
+		t.Fatal(items)
+	}
+	ips := make(map[string]bool)
+	for _, item := range items {
+		if item.ServiceName != "a.yuerblog.cc" || item.Port != 80 || item.Breaker == nil || item.Health == nil || !item.Health.Healthy {
+			t.Fatal(item)
+		}
+		ips[item.Ip] = true
+	}
+	if !ips["10.0.0.1"] || !ips["10.0.0.2"] {
+		t.Fatal(ips)
+	}
+
+	// 桶计数与其他字段一样为小写下划线命名
+	var raw []struct {
+		Breaker struct {
+			Buckets []map[string]int `json:"buckets"`
+		} `json:"breaker"`
+	}
+	getJson(t, server.URL+"/breakers", &raw)
+	buckets := raw[0].Breaker.Buckets
+	if len(buckets) == 0 {
+		t.Fatal(raw)
+	}
+	if _, exist := buckets[0]["success"]; !exist {
+		t.Fatal(buckets[0])
+	}
+	if _, exist := buckets[0]["fail"]; !exist {
+		t.Fatal(buckets[0])
+	}
+}
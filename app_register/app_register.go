@@ -0,0 +1,151 @@
+package app_register
+
+import (
+	"sync"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/probe"
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+const (
+	APP_STATUS_UNREGISTERED = 0 // 未注册
+	APP_STATUS_ENABLED      = 1 // 已注册，接收流量
+	APP_STATUS_DISABLED     = 2 // 已注册，但被摘除流量(Enable=false)
+)
+
+// 配置
+type AppRegisterConfig struct {
+	ServiceName      string                              // 注册的服务名
+	Ip               string                              // 注册的应用IP
+	Port             uint64                              // 注册的应用端口
+	Weight           float64                             // 注册权重
+	Prober           probe.Prober                        // 应用探测器
+	ProbeInterval    time.Duration                       // 探测间隔
+	ProbeTimeout     time.Duration                       // 单次探测超时
+	SuccessThreshold int                                 // 连续成功多少次，视为健康
+	FailThreshold    int                                 // 连续失败多少次，视为异常
+	Sd               service_discovery.IServiceDiscovery // 服务注册
+}
+
+// 应用探活与服务注册
+type AppRegister struct {
+	config       *AppRegisterConfig
+	mu           sync.Mutex
+	status       int
+	successCount int // 连续成功次数
+	failCount    int // 连续失败次数
+	stopNotify   chan byte
+	doneNotify   chan byte
+}
+
+// 新建应用注册器
+func NewAppRegister(appRegisterConfig *AppRegisterConfig) (appRegister *AppRegister, err error) {
+	appRegister = &AppRegister{
+		config:     appRegisterConfig,
+		status:     APP_STATUS_UNREGISTERED,
+		stopNotify: make(chan byte),
+		doneNotify: make(chan byte),
+	}
+	return
+}
+
+// 当前注册状态
+func (appRegister *AppRegister) Status() int {
+	appRegister.mu.Lock()
+	defer appRegister.mu.Unlock()
+	return appRegister.status
+}
+
+// 根据一次探测结果推进状态，调用nacos期间不持有锁，避免Status/Stop被慢请求阻塞
+func (appRegister *AppRegister) onProbe(success bool) {
+	config := appRegister.config
+
+	appRegister.mu.Lock()
+	if success {
+		appRegister.successCount++
+		appRegister.failCount = 0
+	} else {
+		appRegister.failCount++
+		appRegister.successCount = 0
+	}
+	status := appRegister.status
+	nextStatus := status
+	switch status {
+	case APP_STATUS_UNREGISTERED, APP_STATUS_DISABLED: // 连续成功N次，注册上线/恢复流量
+		if appRegister.successCount >= config.SuccessThreshold {
+			nextStatus = APP_STATUS_ENABLED
+		}
+	case APP_STATUS_ENABLED: // 连续失败M次，摘除流量
+		if appRegister.failCount >= config.FailThreshold {
+			nextStatus = APP_STATUS_DISABLED
+		}
+	}
+	appRegister.mu.Unlock()
+	if nextStatus == status {
+		return
+	}
+
+	var err error
+	if status == APP_STATUS_UNREGISTERED {
+		err = config.Sd.RegisterService(&service_discovery.RegisterServiceOptions{
+			ServiceName: config.ServiceName,
+			Ip:          config.Ip,
+			Port:        config.Port,
+			Weight:      config.Weight,
+			Enable:      true,
+		})
+	} else {
+		err = appRegister.updateEnable(nextStatus == APP_STATUS_ENABLED)
+	}
+	// 调用nacos失败则状态不变，下次探测时再次尝试
+	if err != nil {
+		return
+	}
+
+	appRegister.mu.Lock()
+	appRegister.status = nextStatus
+	appRegister.mu.Unlock()
+}
+
+func (appRegister *AppRegister) updateEnable(enable bool) (err error) {
+	config := appRegister.config
+	err = config.Sd.UpdateService(&service_discovery.UpdateServiceOptions{
+		ServiceName: config.ServiceName,
+		Ip:          config.Ip,
+		Port:        config.Port,
+		Weight:      config.Weight,
+		Enable:      enable,
+	})
+	return
+}
+
+// 持续探测应用，直到Stop
+func (appRegister *AppRegister) Run() {
+	defer close(appRegister.doneNotify)
+
+	ticker := time.NewTicker(appRegister.config.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		err := probe.ProbeWithTimeout(appRegister.config.Prober, appRegister.config.ProbeTimeout)
+		appRegister.onProbe(err == nil)
+
+		select {
+		case <-appRegister.stopNotify:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 停止探测（不会取消注册）
+func (appRegister *AppRegister) Stop() {
+	appRegister.mu.Lock()
+	select {
+	case <-appRegister.stopNotify:
+	default:
+		close(appRegister.stopNotify)
+	}
+	appRegister.mu.Unlock()
+	<-appRegister.doneNotify
+}
@@ -0,0 +1,116 @@
+package app_register
+
+import (
+	"testing"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 只记录注册调用的服务发现
+type fakeSd struct {
+	service_discovery.IServiceDiscovery
+	registered bool
+	enable     bool
+}
+
+func (sd *fakeSd) RegisterService(options *service_discovery.RegisterServiceOptions) (err error) {
+	sd.registered = true
+	sd.enable = options.Enable
+	return
+}
+
+func (sd *fakeSd) UpdateService(options *service_discovery.UpdateServiceOptions) (err error) {
+	sd.enable = options.Enable
+	return
+}
+
+func TestAppRegister(t *testing.T) {
+	sd := &fakeSd{}
+	appRegister, _ := NewAppRegister(&AppRegisterConfig{
+		ServiceName:      "app",
+		Ip:               "127.0.0.1",
+		Port:             8080,
+		Weight:           1,
+		SuccessThreshold: 3,
+		FailThreshold:    2,
+		Sd:               sd,
+	})
+
+	// 成功次数不足，不注册
+	appRegister.onProbe(true)
+	appRegister.onProbe(true)
+	if sd.registered || appRegister.Status() != APP_STATUS_UNREGISTERED {
+		t.Fatal()
+	}
+
+	// 连续成功3次，注册
+	appRegister.onProbe(true)
+	if !sd.registered || !sd.enable || appRegister.Status() != APP_STATUS_ENABLED {
+		t.Fatal()
+	}
+
+	// 失败被成功打断，不摘除
+	appRegister.onProbe(false)
+	appRegister.onProbe(true)
+	appRegister.onProbe(false)
+	if !sd.enable {
+		t.Fatal()
+	}
+
+	// 连续失败2次，摘除
+	appRegister.onProbe(false)
+	if sd.enable || appRegister.Status() != APP_STATUS_DISABLED {
+		t.Fatal()
+	}
+
+	// 连续成功3次，恢复
+	appRegister.onProbe(true)
+	appRegister.onProbe(true)
+	appRegister.onProbe(true)
+	if !sd.enable || appRegister.Status() != APP_STATUS_ENABLED {
+		t.Fatal()
+	}
+}
+
+// 注册调用阻塞直到release关闭
+type slowSd struct {
+	fakeSd
+	calling chan byte
+	release chan byte
+}
+
+func (sd *slowSd) RegisterService(options *service_discovery.RegisterServiceOptions) (err error) {
+	close(sd.calling)
+	<-sd.release
+	return sd.fakeSd.RegisterService(options)
+}
+
+func TestStatusDuringRegister(t *testing.T) {
+	sd := &slowSd{calling: make(chan byte), release: make(chan byte)}
+	appRegister, _ := NewAppRegister(&AppRegisterConfig{ServiceName: "app", SuccessThreshold: 1, FailThreshold: 1, Sd: sd})
+	done := make(chan byte)
+	go func() {
+		appRegister.onProbe(true)
+		close(done)
+	}()
+
+	// nacos调用期间查询状态不被阻塞
+	<-sd.calling
+	statusChan := make(chan int, 1)
+	go func() { statusChan <- appRegister.Status() }()
+	select {
+	case status := <-statusChan:
+		if status != APP_STATUS_UNREGISTERED {
+			t.Fatal(status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Status被nacos调用阻塞")
+	}
+
+	close(sd.release)
+	<-done
+	if appRegister.Status() != APP_STATUS_ENABLED {
+		t.Fatal(appRegister.Status())
+	}
+}
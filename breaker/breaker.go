@@ -71,6 +71,27 @@ func (breaker *Breaker) Debug() {
 	fmt.Println("------")
 }
 
+// 熔断器状态快照
+type State struct {
+	Status  int      // 熔断状态
+	Buckets []Bucket // 最近N秒的统计，最后1个为当前秒
+}
+
+func (breaker *Breaker) State() (state *State) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	breaker.update()
+	state = &State{
+		Status:  breaker.status,
+		Buckets: make([]Bucket, 0, len(breaker.buckets)),
+	}
+	for _, bucket := range breaker.buckets {
+		state.Buckets = append(state.Buckets, *bucket)
+	}
+	return
+}
+
 func (breaker *Breaker) update() {
 	now := time.Now()
 
@@ -120,8 +141,8 @@ func (breaker *Breaker) RecordSuccess() {
 	}
 }
 
-// 记录失败
-func (breaker *Breaker) RecordFail() {
+// 记录失败，返回本次是否触发了"全断开"
+func (breaker *Breaker) RecordFail() (disconnected bool) {
 	breaker.mu.Lock()
 	defer breaker.mu.Unlock()
 
@@ -141,7 +162,9 @@ func (breaker *Breaker) RecordFail() {
 	if needDisconnect {
 		breaker.status = BREAKER_STATUS_DISCONNECT
 		breaker.disconnectTime = time.Now()
+		disconnected = true
 	}
+	return
 }
 
 // 是否允许操作？
@@ -0,0 +1,93 @@
+package flags
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// 按服务名覆盖的配置
+type ServiceConfig struct {
+	LoadBalance string `json:"load_balance"` // 负载均衡策略
+	HashHeader  string `json:"hash_header"`  // 一致性哈希使用的请求头
+
+	Retry *RetryConfig `json:"retry"` // 重试策略，未配置的字段沿用命令行
+	Hedge *HedgeConfig `json:"hedge"` // 对冲请求，不配置则不对冲
+
+	Timeout *TimeoutConfig `json:"timeout"` // 超时，未配置的字段沿用命令行
+
+	H2c bool `json:"h2c"` // 以明文HTTP/2转发，gRPC请求总是如此
+}
+
+// 服务的超时
+type TimeoutConfig struct {
+	DialMs           int `json:"dial_ms"`            // 建连超时
+	TLSHandshakeMs   int `json:"tls_handshake_ms"`   // TLS握手超时
+	ResponseHeaderMs int `json:"response_header_ms"` // 等待应答头超时
+	TotalMs          int `json:"total_ms"`           // 整个请求的截止时间
+	TunnelIdleMs     int `json:"tunnel_idle_ms"`     // 隧道空闲超时
+}
+
+// 对冲请求
+type HedgeConfig struct {
+	DelayMs    int     `json:"delay_ms"`   // 固定对冲延迟
+	Percentile float64 `json:"percentile"` // 未配置固定延迟时，取近期首字节耗时的分位数，默认95
+}
+
+// 服务的重试策略
+type RetryConfig struct {
+	Attempts        int      `json:"attempts"`           // 最多尝试次数，含首次
+	Methods         []string `json:"methods"`            // 可重试的方法
+	StatusCodes     []int    `json:"status_codes"`       // 可重试的状态码
+	RetryOn         []string `json:"retry_on"`           // 可重试的错误条件：connect-failure|reset|timeout
+	BackoffMs       int      `json:"backoff_ms"`         // 退避基数
+	MaxBackoffMs    int      `json:"max_backoff_ms"`     // 退避上限
+	PerTryTimeoutMs int      `json:"per_try_timeout_ms"` // 单次尝试超时
+}
+
+// 配置文件（JSON）
+type FileConfig struct {
+	Services      map[string]*ServiceConfig `json:"services"`       // 服务名 -> 配置
+	PortMappings  []*PortMappingConfig      `json:"port_mappings"`  // 本地端口到服务的静态映射
+	UdpMappings   []*UdpMappingConfig       `json:"udp_mappings"`   // 本地UDP端口到服务的静态映射
+	IngressRoutes []*IngressRouteConfig     `json:"ingress_routes"` // 入口按Host、路径前缀到服务的路由
+}
+
+// 本地端口到服务的静态映射
+type PortMappingConfig struct {
+	Listen   string `json:"listen"`   // 本地监听地址，如127.0.0.1:13306
+	Service  string `json:"service"`  // nacos服务名
+	Fallback string `json:"fallback"` // 服务发现失败时连接的地址，可选
+}
+
+// 入口路由规则
+type IngressRouteConfig struct {
+	Host        string `json:"host"`         // 匹配的Host，为空匹配任意Host
+	PathPrefix  string `json:"path_prefix"`  // 匹配的路径前缀，为空匹配任意路径
+	Service     string `json:"service"`      // nacos服务名
+	StripPrefix bool   `json:"strip_prefix"` // 转发前去掉路径前缀
+}
+
+// 本地UDP端口到服务的静态映射
+type UdpMappingConfig struct {
+	Listen        string `json:"listen"`          // 本地监听地址，如127.0.0.1:8125
+	Service       string `json:"service"`         // nacos服务名
+	IdleTimeoutMs int    `json:"idle_timeout_ms"` // 会话空闲过期时间，可选
+}
+
+// 加载配置文件，路径为空时返回空配置
+func loadFileConfig(path string) (fileConfig *FileConfig, err error) {
+	fileConfig = &FileConfig{}
+	if path != "" {
+		var data []byte
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return
+		}
+		if err = json.Unmarshal(data, fileConfig); err != nil {
+			return
+		}
+	}
+	if fileConfig.Services == nil {
+		fileConfig.Services = make(map[string]*ServiceConfig)
+	}
+	return
+}
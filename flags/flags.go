@@ -3,9 +3,15 @@ package flags
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"net"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/owenliang/nacos-reverse-proxy/forward_proxy"
+	"github.com/owenliang/nacos-reverse-proxy/probe"
 	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
 )
 
@@ -17,7 +23,106 @@ var (
 	ListenAddr string
 	RetryTimes int
 
+	ReconcileInterval time.Duration
+	LoadBalance       string
+	HashHeader        string
+	ConfigFile        string
+	FailStatus        string
+	SpoolKB           int64
+
+	// HTTP重试策略
+	RetryMethods       string
+	RetryStatus        string
+	RetryOn            string
+	RetryBackoff       time.Duration
+	RetryMaxBackoff    time.Duration
+	RetryPerTryTimeout time.Duration
+
+	// 超时
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	RequestTimeout        time.Duration
+	TunnelIdleTimeout     time.Duration
+	TimeoutHeader         string
+	DeadlineHeader        string
+
+	// 重试预算
+	RetryBudgetPercent float64
+	RetryBudgetMin     float64
+	RetryBudgetWindow  time.Duration
+
+	// 上游连接池
+	UpstreamMaxIdle     int
+	UpstreamIdleTimeout time.Duration
+	UpstreamMaxLifetime time.Duration
+
+	// 上游节点主动健康检查
+	UpstreamCheck         string
+	UpstreamCheckPath     string
+	UpstreamCheckInterval time.Duration
+	UpstreamCheckTimeout  time.Duration
+	UpstreamCheckSuccess  int
+	UpstreamCheckFail     int
+
+	// 服务缓存
+	ServiceIdleTimeout time.Duration
+	NegativeTTL        time.Duration
+	MaxServices        int
+	SnapshotDir        string
+	SnapshotRetention  time.Duration
+
+	AdminListenAddr string
+
+	// SOCKS5
+	Socks5ListenAddr string
+	Socks5Username   string
+	Socks5Password   string
+
+	// 入口（反向代理）
+	IngressListenAddr string
+
+	// 转发header
+	ProxyName               string
+	DisableForwardedHeaders bool
+	IdentityHeader          string
+
+	// CONNECT允许的目标端口
+	ConnectPorts   string
+	ConnectPortSet map[int]bool
+
+	// 应用注册
+	ServiceName           string
+	AppIp                 string
+	AppPort               int
+	AppWeight             float64
+	ProbeType             string
+	ProbePath             string
+	ProbeCommand          string
+	ProbeInterval         time.Duration
+	ProbeTimeout          time.Duration
+	ProbeSuccessThreshold int
+	ProbeFailThreshold    int
+
+	// 优雅退出
+	DrainTimeout   time.Duration
+	AppExitTimeout time.Duration
+
 	NacosNodes []service_discovery.NacosNode
+	Config     *FileConfig
+
+	FailStatusCodes map[int]bool
+	Retry           *forward_proxy.RetryPolicy
+	ServiceRetry    map[string]*forward_proxy.RetryPolicy
+	RetryBudget     *forward_proxy.RetryBudgetConfig
+	ServiceHedge    map[string]*forward_proxy.HedgePolicy
+	Timeout         *forward_proxy.TimeoutPolicy
+	ServiceTimeout  map[string]*forward_proxy.TimeoutPolicy
+	H2cServices     map[string]bool
+	KnownServices   map[string]bool // 配置文件services中声明的服务名
+	PortMappings    []*forward_proxy.PortMapping
+	UdpMappings     []*forward_proxy.UdpMapping
+	IngressRoutes   []*forward_proxy.IngressRoute
 )
 
 func init() {
@@ -26,10 +131,205 @@ func init() {
 	flag.StringVar(&Cluster, "cluster", "", "nacos cluster")
 	flag.StringVar(&Nodes, "nodes", "", "nacos nodes")
 	flag.StringVar(&ListenAddr, "listen", "", "proxy listen address")
-	flag.IntVar(&RetryTimes, "retry", 3, "retry times for http proxy")
+	flag.IntVar(&RetryTimes, "retry", 3, "max attempts for http proxy, including the first one")
+	flag.StringVar(&RetryMethods, "retry-methods", "GET,HEAD,OPTIONS,TRACE,PUT,DELETE", "methods allowed to retry on reset/timeout/status, connect failures are retried for all methods")
+	flag.StringVar(&RetryStatus, "retry-status", "", "upstream status codes to retry, comma separated")
+	flag.StringVar(&RetryOn, "retry-on", "connect-failure,reset,timeout", "retry conditions, comma separated: connect-failure|reset|timeout")
+	flag.DurationVar(&RetryBackoff, "retry-backoff", 25*time.Millisecond, "base of jittered exponential backoff between retries")
+	flag.DurationVar(&RetryMaxBackoff, "retry-max-backoff", 250*time.Millisecond, "max backoff between retries")
+	flag.DurationVar(&DialTimeout, "dial-timeout", 5*time.Second, "upstream connect timeout, 0 for unlimited")
+	flag.DurationVar(&TLSHandshakeTimeout, "tls-timeout", 10*time.Second, "upstream tls handshake timeout, 0 for unlimited")
+	flag.DurationVar(&ResponseHeaderTimeout, "response-header-timeout", 60*time.Second, "timeout awaiting upstream response headers per attempt, 0 for unlimited")
+	flag.DurationVar(&RequestTimeout, "request-timeout", 0, "total deadline of a http request including retries and response body, 0 for unlimited")
+	flag.DurationVar(&TunnelIdleTimeout, "tunnel-idle-timeout", 5*time.Minute, "close tunnels idle in both directions for this long, 0 for unlimited")
+	flag.StringVar(&TimeoutHeader, "timeout-header", "X-Proxy-Timeout-Ms", "request header for callers to shorten the total deadline in milliseconds, empty to disable")
+	flag.StringVar(&DeadlineHeader, "deadline-header", "X-Request-Timeout-Ms", "request header carrying the remaining deadline in milliseconds across hops, honored on incoming requests, empty to disable")
+	flag.Float64Var(&RetryBudgetPercent, "retry-budget-percent", 20, "retries allowed as a percentage of recent successful requests, per service and globally, negative to disable the budget")
+	flag.Float64Var(&RetryBudgetMin, "retry-budget-min", 10, "retries allowed per second regardless of traffic")
+	flag.DurationVar(&RetryBudgetWindow, "retry-budget-window", 10*time.Second, "window of the retry budget")
+	flag.DurationVar(&RetryPerTryTimeout, "retry-per-try-timeout", 0, "timeout of each attempt, 0 for unlimited")
+	flag.DurationVar(&ReconcileInterval, "reconcile-interval", 30*time.Second, "interval of full instance pull besides nacos push")
+	flag.StringVar(&LoadBalance, "lb", service_discovery.LB_POLICY_RANDOM, "load balance policy: random|round_robin|least_request|p2c|hash")
+	flag.StringVar(&HashHeader, "lb-hash-header", "", "request header for hash load balance, client ip if empty")
+	flag.StringVar(&ConfigFile, "config", "", "json config file for per-service settings")
+	flag.StringVar(&FailStatus, "fail-status", "502,503,504", "upstream status codes counted as instance failure, comma separated")
+	flag.Int64Var(&SpoolKB, "spool-kb", 64, "request bodies up to this size are buffered for retry, larger ones are streamed without retry")
+	flag.IntVar(&UpstreamMaxIdle, "upstream-max-idle", 32, "max idle connections kept per upstream instance")
+	flag.DurationVar(&UpstreamIdleTimeout, "upstream-idle-timeout", 90*time.Second, "idle timeout of pooled upstream connections")
+	flag.DurationVar(&UpstreamMaxLifetime, "upstream-max-lifetime", 10*time.Minute, "max lifetime of pooled upstream connections, 0 for unlimited")
+	flag.StringVar(&UpstreamCheck, "upstream-check", "", "active health check for discovered instances: tcp|http, empty to disable")
+	flag.StringVar(&UpstreamCheckPath, "upstream-check-path", "/", "http path for upstream health check")
+	flag.DurationVar(&UpstreamCheckInterval, "upstream-check-interval", 2*time.Second, "upstream health check interval")
+	flag.DurationVar(&UpstreamCheckTimeout, "upstream-check-timeout", 1*time.Second, "upstream health check timeout")
+	flag.IntVar(&UpstreamCheckSuccess, "upstream-check-success", 1, "consecutive successes to bring an instance back")
+	flag.IntVar(&UpstreamCheckFail, "upstream-check-fail", 2, "consecutive failures to take an instance out")
+	flag.DurationVar(&ServiceIdleTimeout, "service-idle-timeout", 10*time.Minute, "evict cached services not requested for this long")
+	flag.DurationVar(&NegativeTTL, "negative-ttl", 30*time.Second, "how long hosts unknown to nacos go straight to dns")
+	flag.IntVar(&MaxServices, "max-services", 1024, "max number of cached services")
+	flag.StringVar(&SnapshotDir, "snapshot-dir", "", "dir to persist instance snapshots for nacos outage, empty to disable")
+	flag.DurationVar(&SnapshotRetention, "snapshot-retention", 24*time.Hour, "keep snapshots of evicted services for this long since last written")
+	flag.StringVar(&ProxyName, "proxy-name", "", "proxy name used in Via and identity header, default to hostname")
+	flag.BoolVar(&DisableForwardedHeaders, "no-forwarded-headers", false, "do not add Via/X-Forwarded-For/Forwarded headers")
+	flag.StringVar(&IdentityHeader, "identity-header", "X-Proxy-Identity", "header telling upstream which proxy and nacos instance handled the call, empty to disable")
+	flag.StringVar(&ConnectPorts, "connect-ports", "", "comma separated ports allowed for CONNECT, empty to allow all")
+	flag.StringVar(&Socks5ListenAddr, "socks5-listen", "", "socks5 listen address, empty to disable")
+	flag.StringVar(&Socks5Username, "socks5-user", "", "socks5 username, empty for no authentication")
+	flag.StringVar(&Socks5Password, "socks5-password", "", "socks5 password")
+	flag.StringVar(&IngressListenAddr, "ingress-listen", "", "ingress listen address for plain http requests routed by ingress_routes in config file, empty to disable")
+	flag.StringVar(&AdminListenAddr, "admin-listen", "", "admin api listen address, empty to disable")
+
+	flag.StringVar(&ServiceName, "service", "", "service name to register for the app, empty to disable registration")
+	flag.StringVar(&AppIp, "app-ip", "", "app ip to register, default to the first non-loopback ipv4")
+	flag.IntVar(&AppPort, "app-port", 0, "app port to register")
+	flag.Float64Var(&AppWeight, "app-weight", 1, "app weight to register")
+	flag.StringVar(&ProbeType, "probe", probe.PROBE_TYPE_TCP, "app probe type: tcp|http|exec")
+	flag.StringVar(&ProbePath, "probe-path", "/", "app probe path for http probe")
+	flag.StringVar(&ProbeCommand, "probe-cmd", "", "app probe command for exec probe")
+	flag.DurationVar(&ProbeInterval, "probe-interval", 1*time.Second, "app probe interval")
+	flag.DurationVar(&ProbeTimeout, "probe-timeout", 1*time.Second, "app probe timeout")
+	flag.IntVar(&ProbeSuccessThreshold, "probe-success", 3, "consecutive successes to register/enable the app")
+	flag.IntVar(&ProbeFailThreshold, "probe-fail", 3, "consecutive failures to disable the app")
+	flag.DurationVar(&DrainTimeout, "drain-timeout", 10*time.Second, "max time to drain in-flight requests and tunnels on exit")
+	flag.DurationVar(&AppExitTimeout, "app-exit-timeout", 30*time.Second, "max time to wait for the app to exit on exit")
 	flag.Parse()
 }
 
+// 取本机第一个非回环IPv4地址
+func localIp() (ip string, err error) {
+	var addrs []net.Addr
+	if addrs, err = net.InterfaceAddrs(); err != nil {
+		return
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			ip = ipNet.IP.String()
+			return
+		}
+	}
+	err = errors.New("找不到本机IP")
+	return
+}
+
+// 解析逗号分隔的整数列表，如状态码、端口
+func parseIntSet(codes string) (set map[int]bool, err error) {
+	set = make(map[int]bool)
+	for _, code := range strings.Split(codes, ",") {
+		if code = strings.TrimSpace(code); code == "" {
+			continue
+		}
+		var ncode int
+		if ncode, err = strconv.Atoi(code); err != nil {
+			return
+		}
+		set[ncode] = true
+	}
+	return
+}
+
+// 解析逗号分隔的字符串列表
+func splitList(list string) (items []string) {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// 在base基础上用配置文件覆盖重试策略
+func buildRetryPolicy(base *forward_proxy.RetryPolicy, retryConfig *RetryConfig) (policy *forward_proxy.RetryPolicy, err error) {
+	copied := *base
+	policy = &copied
+	if retryConfig.Attempts != 0 {
+		policy.MaxAttempts = retryConfig.Attempts
+	}
+	if retryConfig.Methods != nil {
+		policy.Methods = make(map[string]bool)
+		for _, method := range retryConfig.Methods {
+			policy.Methods[strings.ToUpper(method)] = true
+		}
+	}
+	if retryConfig.StatusCodes != nil {
+		policy.StatusCodes = make(map[int]bool)
+		for _, code := range retryConfig.StatusCodes {
+			policy.StatusCodes[code] = true
+		}
+	}
+	if retryConfig.RetryOn != nil {
+		policy.RetryOn = make(map[string]bool)
+		for _, condition := range retryConfig.RetryOn {
+			policy.RetryOn[condition] = true
+		}
+	}
+	if retryConfig.BackoffMs != 0 {
+		policy.BaseBackoff = time.Duration(retryConfig.BackoffMs) * time.Millisecond
+	}
+	if retryConfig.MaxBackoffMs != 0 {
+		policy.MaxBackoff = time.Duration(retryConfig.MaxBackoffMs) * time.Millisecond
+	}
+	if retryConfig.PerTryTimeoutMs != 0 {
+		policy.PerTryTimeout = time.Duration(retryConfig.PerTryTimeoutMs) * time.Millisecond
+	}
+	err = checkRetryPolicy(policy)
+	return
+}
+
+// 校验重试策略
+func checkRetryPolicy(policy *forward_proxy.RetryPolicy) (err error) {
+	if policy.MaxAttempts <= 0 || policy.BaseBackoff < 0 || policy.MaxBackoff < 0 || policy.PerTryTimeout < 0 {
+		err = errors.New("重试参数非法")
+		return
+	}
+	for condition := range policy.RetryOn {
+		if condition != forward_proxy.RETRY_ON_CONNECT_FAILURE && condition != forward_proxy.RETRY_ON_RESET && condition != forward_proxy.RETRY_ON_TIMEOUT {
+			err = fmt.Errorf("重试条件非法: %s", condition)
+			return
+		}
+	}
+	return
+}
+
+// 在base基础上用配置文件覆盖超时
+func buildTimeoutPolicy(base *forward_proxy.TimeoutPolicy, timeoutConfig *TimeoutConfig) (policy *forward_proxy.TimeoutPolicy, err error) {
+	copied := *base
+	policy = &copied
+	override := func(ms int, timeout *time.Duration) {
+		if ms != 0 {
+			*timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	override(timeoutConfig.DialMs, &policy.Dial)
+	override(timeoutConfig.TLSHandshakeMs, &policy.TLSHandshake)
+	override(timeoutConfig.ResponseHeaderMs, &policy.ResponseHeader)
+	override(timeoutConfig.TotalMs, &policy.Total)
+	override(timeoutConfig.TunnelIdleMs, &policy.TunnelIdle)
+	err = checkTimeoutPolicy(policy)
+	return
+}
+
+// 校验超时
+func checkTimeoutPolicy(policy *forward_proxy.TimeoutPolicy) (err error) {
+	if policy.Dial < 0 || policy.TLSHandshake < 0 || policy.ResponseHeader < 0 || policy.Total < 0 || policy.TunnelIdle < 0 {
+		err = errors.New("超时参数非法")
+	}
+	return
+}
+
+// 各服务的负载均衡配置
+func ServiceLoadBalance() (serviceLoadBalance map[string]*service_discovery.LoadBalanceConfig) {
+	serviceLoadBalance = make(map[string]*service_discovery.LoadBalanceConfig)
+	for serviceName, serviceConfig := range Config.Services {
+		if serviceConfig.LoadBalance == "" {
+			continue
+		}
+		serviceLoadBalance[serviceName] = &service_discovery.LoadBalanceConfig{
+			Policy:     serviceConfig.LoadBalance,
+			HashHeader: serviceConfig.HashHeader,
+		}
+	}
+	return
+}
+
 func Check() (err error) {
 	if Namespace == "" || Group == "" || Cluster == "" || Nodes == "" || ListenAddr == "" || RetryTimes == 0 {
 		err = errors.New("命令行参数为空")
@@ -51,5 +351,192 @@ func Check() (err error) {
 		err = errors.New("nacos nodes empty")
 		return
 	}
+
+	if ProxyName == "" {
+		if ProxyName, err = os.Hostname(); err != nil {
+			return
+		}
+	}
+
+	if Config, err = loadFileConfig(ConfigFile); err != nil {
+		return
+	}
+
+	if FailStatusCodes, err = parseIntSet(FailStatus); err != nil {
+		return
+	}
+	if ConnectPortSet, err = parseIntSet(ConnectPorts); err != nil {
+		return
+	}
+
+	// 重试策略
+	Retry = &forward_proxy.RetryPolicy{
+		MaxAttempts:   RetryTimes,
+		Methods:       make(map[string]bool),
+		RetryOn:       make(map[string]bool),
+		BaseBackoff:   RetryBackoff,
+		MaxBackoff:    RetryMaxBackoff,
+		PerTryTimeout: RetryPerTryTimeout,
+	}
+	for _, method := range splitList(RetryMethods) {
+		Retry.Methods[strings.ToUpper(method)] = true
+	}
+	for _, condition := range splitList(RetryOn) {
+		Retry.RetryOn[condition] = true
+	}
+	if Retry.StatusCodes, err = parseIntSet(RetryStatus); err != nil {
+		return
+	}
+	if err = checkRetryPolicy(Retry); err != nil {
+		return
+	}
+	if RetryBudgetPercent >= 0 {
+		if RetryBudgetMin < 0 || RetryBudgetWindow < time.Second {
+			err = errors.New("重试预算参数非法")
+			return
+		}
+		RetryBudget = &forward_proxy.RetryBudgetConfig{
+			Percent:      RetryBudgetPercent,
+			MinPerSecond: RetryBudgetMin,
+			Window:       int(RetryBudgetWindow / time.Second),
+		}
+	}
+	// 超时
+	Timeout = &forward_proxy.TimeoutPolicy{
+		Dial:           DialTimeout,
+		TLSHandshake:   TLSHandshakeTimeout,
+		ResponseHeader: ResponseHeaderTimeout,
+		Total:          RequestTimeout,
+		TunnelIdle:     TunnelIdleTimeout,
+	}
+	if err = checkTimeoutPolicy(Timeout); err != nil {
+		return
+	}
+	ServiceTimeout = make(map[string]*forward_proxy.TimeoutPolicy)
+	for serviceName, serviceConfig := range Config.Services {
+		if serviceConfig.Timeout == nil {
+			continue
+		}
+		if ServiceTimeout[serviceName], err = buildTimeoutPolicy(Timeout, serviceConfig.Timeout); err != nil {
+			return
+		}
+	}
+
+	H2cServices = make(map[string]bool)
+	KnownServices = make(map[string]bool)
+	for serviceName, serviceConfig := range Config.Services {
+		if serviceConfig.H2c {
+			H2cServices[serviceName] = true
+		}
+		KnownServices[serviceName] = true
+	}
+
+	// 端口映射
+	for _, mapping := range Config.PortMappings {
+		if mapping.Listen == "" || mapping.Service == "" {
+			err = errors.New("端口映射缺少listen或service")
+			return
+		}
+		PortMappings = append(PortMappings, &forward_proxy.PortMapping{
+			ListenAddr:   mapping.Listen,
+			ServiceName:  mapping.Service,
+			FallbackAddr: mapping.Fallback,
+		})
+	}
+	for _, mapping := range Config.UdpMappings {
+		if mapping.Listen == "" || mapping.Service == "" || mapping.IdleTimeoutMs < 0 {
+			err = errors.New("UDP端口映射缺少listen或service，或idle_timeout_ms非法")
+			return
+		}
+		UdpMappings = append(UdpMappings, &forward_proxy.UdpMapping{
+			ListenAddr:  mapping.Listen,
+			ServiceName: mapping.Service,
+			IdleTimeout: time.Duration(mapping.IdleTimeoutMs) * time.Millisecond,
+		})
+	}
+
+	// 入口路由
+	for _, route := range Config.IngressRoutes {
+		if route.Service == "" {
+			err = errors.New("入口路由缺少service")
+			return
+		}
+		if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+			err = fmt.Errorf("入口路由的path_prefix需以/开头: %s", route.PathPrefix)
+			return
+		}
+		IngressRoutes = append(IngressRoutes, &forward_proxy.IngressRoute{
+			Host:        route.Host,
+			PathPrefix:  route.PathPrefix,
+			ServiceName: route.Service,
+			StripPrefix: route.StripPrefix,
+		})
+	}
+	if IngressListenAddr != "" && len(IngressRoutes) == 0 {
+		err = errors.New("开启入口需要配置ingress_routes")
+		return
+	}
+
+	ServiceHedge = make(map[string]*forward_proxy.HedgePolicy)
+	for serviceName, serviceConfig := range Config.Services {
+		if serviceConfig.Hedge == nil {
+			continue
+		}
+		hedge := &forward_proxy.HedgePolicy{
+			Delay:      time.Duration(serviceConfig.Hedge.DelayMs) * time.Millisecond,
+			Percentile: serviceConfig.Hedge.Percentile,
+		}
+		if hedge.Percentile == 0 {
+			hedge.Percentile = 95
+		}
+		if hedge.Delay < 0 || hedge.Percentile < 0 || hedge.Percentile > 100 {
+			err = fmt.Errorf("对冲参数非法: %s", serviceName)
+			return
+		}
+		ServiceHedge[serviceName] = hedge
+	}
+	ServiceRetry = make(map[string]*forward_proxy.RetryPolicy)
+	for serviceName, serviceConfig := range Config.Services {
+		if serviceConfig.Retry == nil {
+			continue
+		}
+		if ServiceRetry[serviceName], err = buildRetryPolicy(Retry, serviceConfig.Retry); err != nil {
+			return
+		}
+	}
+	if UpstreamMaxIdle < 0 || UpstreamIdleTimeout <= 0 || UpstreamMaxLifetime < 0 {
+		err = errors.New("上游连接池参数非法")
+		return
+	}
+	if SpoolKB < 0 {
+		err = errors.New("spool-kb非法")
+		return
+	}
+
+	if DrainTimeout < 0 || AppExitTimeout < 0 {
+		err = errors.New("退出超时参数非法")
+		return
+	}
+
+	// 应用注册
+	if ServiceName != "" {
+		if AppPort <= 0 || AppPort > 65535 {
+			err = fmt.Errorf("应用端口非法: %d", AppPort)
+			return
+		}
+		if AppIp == "" {
+			if AppIp, err = localIp(); err != nil {
+				return
+			}
+		}
+		if AppWeight <= 0 || ProbeInterval <= 0 || ProbeTimeout <= 0 || ProbeSuccessThreshold <= 0 || ProbeFailThreshold <= 0 {
+			err = errors.New("应用探测参数非法")
+			return
+		}
+		if ProbeType == probe.PROBE_TYPE_EXEC && strings.TrimSpace(ProbeCommand) == "" {
+			err = errors.New("exec探测命令为空")
+			return
+		}
+	}
 	return
 }
@@ -0,0 +1,165 @@
+package forward_proxy
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+var (
+	retryBudgetExhausted         = expvar.NewInt("proxy_retry_budget_exhausted")          // 预算耗尽而放弃的重试次数
+	retryBudgetExhaustedServices = expvar.NewMap("proxy_retry_budget_exhausted_services") // 服务名 -> 预算耗尽而放弃的重试次数
+)
+
+// 重试预算配置：窗口内的重试数不超过 成功数*Percent/100 + MinPerSecond*Window
+type RetryBudgetConfig struct {
+	Percent      float64 // 重试量占近期成功请求量的百分比
+	MinPerSecond float64 // 每秒保底的重试量，保证低流量时也能重试
+	Window       int     // 统计窗口（秒）
+}
+
+// 秒级统计
+type budgetBucket struct {
+	success int
+	retry   int
+}
+
+// 令牌桶式的重试预算
+type retryBudget struct {
+	mu             sync.Mutex
+	config         *RetryBudgetConfig
+	buckets        []*budgetBucket // 1秒1个桶，最后1个为当前秒
+	lastUpdateTime time.Time
+}
+
+func newRetryBudget(config *RetryBudgetConfig) (budget *retryBudget) {
+	budget = &retryBudget{
+		config:         config,
+		buckets:        make([]*budgetBucket, 0, config.Window),
+		lastUpdateTime: time.Now(),
+	}
+	for i := 0; i < config.Window; i++ {
+		budget.buckets = append(budget.buckets, &budgetBucket{})
+	}
+	return
+}
+
+// 桶偏移
+func (budget *retryBudget) update() {
+	now := time.Now()
+	secs := int(now.Sub(budget.lastUpdateTime).Seconds())
+	if secs >= len(budget.buckets) { // 全部过期
+		for _, bucket := range budget.buckets {
+			bucket.success = 0
+			bucket.retry = 0
+		}
+		budget.lastUpdateTime = now
+	} else if secs > 0 {
+		budget.buckets = append(budget.buckets[:0], budget.buckets[secs:]...)
+		for i := 0; i < secs; i++ {
+			budget.buckets = append(budget.buckets, &budgetBucket{})
+		}
+		budget.lastUpdateTime = budget.lastUpdateTime.Add(time.Duration(secs) * time.Second)
+	}
+}
+
+// 记录成功
+func (budget *retryBudget) recordSuccess() {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.update()
+	budget.buckets[len(budget.buckets)-1].success++
+}
+
+// 还有余量?
+func (budget *retryBudget) hasRoom() bool {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.update()
+	success, retry := 0, 0
+	for _, bucket := range budget.buckets {
+		success += bucket.success
+		retry += bucket.retry
+	}
+	limit := float64(success)*budget.config.Percent/100 + budget.config.MinPerSecond*float64(len(budget.buckets))
+	return float64(retry) < limit
+}
+
+// 记录一次重试
+func (budget *retryBudget) recordRetry() {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.update()
+	budget.buckets[len(budget.buckets)-1].retry++
+}
+
+// 是否长时间无访问
+func (budget *retryBudget) idle() bool {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	return time.Since(budget.lastUpdateTime) > time.Duration(len(budget.buckets))*time.Second
+}
+
+// 按服务及全局的重试预算
+type retryBudgets struct {
+	mu       sync.Mutex
+	config   *RetryBudgetConfig
+	global   *retryBudget
+	services map[string]*retryBudget
+}
+
+// 服务数超过该值时清理长时间无访问的服务
+const RETRY_BUDGET_PRUNE_SIZE = 1024
+
+func newRetryBudgets(config *RetryBudgetConfig) (budgets *retryBudgets) {
+	budgets = &retryBudgets{
+		config:   config,
+		global:   newRetryBudget(config),
+		services: make(map[string]*retryBudget),
+	}
+	return
+}
+
+// 服务的重试预算
+func (budgets *retryBudgets) service(serviceName string) (budget *retryBudget) {
+	budgets.mu.Lock()
+	defer budgets.mu.Unlock()
+	var exist bool
+	if budget, exist = budgets.services[serviceName]; !exist {
+		if len(budgets.services) >= RETRY_BUDGET_PRUNE_SIZE {
+			for name, other := range budgets.services {
+				if other.idle() {
+					delete(budgets.services, name)
+				}
+			}
+		}
+		budget = newRetryBudget(budgets.config)
+		budgets.services[serviceName] = budget
+	}
+	return
+}
+
+// 记录成功
+func (budgets *retryBudgets) recordSuccess(serviceName string) {
+	if budgets == nil {
+		return
+	}
+	budgets.global.recordSuccess()
+	budgets.service(serviceName).recordSuccess()
+}
+
+// 申请一次重试，服务及全局均有余量才允许，否则计入指标
+func (budgets *retryBudgets) acquire(serviceName string) bool {
+	if budgets == nil { // 未开启重试预算
+		return true
+	}
+	budget := budgets.service(serviceName)
+	if !budget.hasRoom() || !budgets.global.hasRoom() {
+		retryBudgetExhausted.Add(1)
+		retryBudgetExhaustedServices.Add(serviceName, 1)
+		return false
+	}
+	budget.recordRetry()
+	budgets.global.recordRetry()
+	return true
+}
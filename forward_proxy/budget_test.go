@@ -0,0 +1,35 @@
+package forward_proxy
+
+import "testing"
+
+func TestRetryBudget(t *testing.T) {
+	budgets := newRetryBudgets(&RetryBudgetConfig{Percent: 50, Window: 10})
+	exhausted := retryBudgetExhausted.Value()
+
+	// 没有成功请求，不允许重试
+	if budgets.acquire("a.svc") {
+		t.Fatal()
+	}
+	// 4次成功，允许2次重试
+	for i := 0; i < 4; i++ {
+		budgets.recordSuccess("a.svc")
+	}
+	if !budgets.acquire("a.svc") || !budgets.acquire("a.svc") || budgets.acquire("a.svc") {
+		t.Fatal()
+	}
+	if retryBudgetExhausted.Value() != exhausted+2 {
+		t.Fatal(retryBudgetExhausted.Value())
+	}
+
+	// 保底重试量按服务各自计算，全局只有1份
+	budgets = newRetryBudgets(&RetryBudgetConfig{MinPerSecond: 0.1, Window: 10})
+	if !budgets.acquire("a.svc") || budgets.acquire("b.svc") {
+		t.Fatal()
+	}
+
+	// 未开启预算不限制
+	var disabled *retryBudgets
+	if !disabled.acquire("a.svc") {
+		t.Fatal()
+	}
+}
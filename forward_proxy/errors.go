@@ -0,0 +1,67 @@
+package forward_proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// 代理自身产生的错误，与应用返回的错误区分
+const (
+	PROXY_ERROR_BAD_REQUEST      = "bad_request"      // 读取客户端请求失败
+	PROXY_ERROR_NO_INSTANCE      = "no_instance"      // 无可用节点且DNS解析失败
+	PROXY_ERROR_CONNECT_FAILED   = "connect_failed"   // 建连失败
+	PROXY_ERROR_UPSTREAM_RESET   = "upstream_reset"   // 连接被重置
+	PROXY_ERROR_UPSTREAM_TIMEOUT = "upstream_timeout" // 超时
+	PROXY_ERROR_DENIED           = "denied"           // 策略拒绝
+	PROXY_ERROR_NO_ROUTE         = "no_route"         // 入口没有匹配的路由规则
+	PROXY_ERROR_SHUTTING_DOWN    = "shutting_down"    // 代理退出中
+)
+
+// 服务发现失败且没有可解析的地址，归为PROXY_ERROR_NO_INSTANCE
+var errNoInstance = errors.New("没有可用instance")
+
+// 错误类型对应的状态码
+var proxyErrorStatus = map[string]int{
+	PROXY_ERROR_BAD_REQUEST:      http.StatusBadRequest,
+	PROXY_ERROR_NO_INSTANCE:      http.StatusServiceUnavailable,
+	PROXY_ERROR_CONNECT_FAILED:   http.StatusBadGateway,
+	PROXY_ERROR_UPSTREAM_RESET:   http.StatusBadGateway,
+	PROXY_ERROR_UPSTREAM_TIMEOUT: http.StatusGatewayTimeout,
+	PROXY_ERROR_DENIED:           http.StatusForbidden,
+	PROXY_ERROR_NO_ROUTE:         http.StatusNotFound,
+	PROXY_ERROR_SHUTTING_DOWN:    http.StatusServiceUnavailable,
+}
+
+// 对转发错误分类
+func classifyProxyError(err error) (kind string) {
+	var dnsErr *net.DNSError
+	switch outcome := classifyError(err); {
+	case errors.As(err, &dnsErr) || errors.Is(err, errNoInstance):
+		kind = PROXY_ERROR_NO_INSTANCE
+	case outcome == OUTCOME_TIMEOUT:
+		kind = PROXY_ERROR_UPSTREAM_TIMEOUT
+	case outcome == OUTCOME_DIAL_FAIL:
+		kind = PROXY_ERROR_CONNECT_FAILED
+	default:
+		kind = PROXY_ERROR_UPSTREAM_RESET
+	}
+	return
+}
+
+// 回复代理错误：状态码、诊断header及文本body
+func writeProxyError(rw http.ResponseWriter, kind string, upstream string, err error) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.Header().Set("X-Proxy-Error", kind)
+	if upstream != "" {
+		rw.Header().Set("X-Proxy-Upstream", upstream)
+	}
+	rw.WriteHeader(proxyErrorStatus[kind])
+	if err != nil {
+		fmt.Fprintf(rw, "proxy error: %s: %v\n", kind, err)
+	} else {
+		fmt.Fprintf(rw, "proxy error: %s\n", kind)
+	}
+}
@@ -0,0 +1,41 @@
+package forward_proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyErrorResponse(t *testing.T) {
+	// 占用一个端口后关闭，确保连接被拒绝
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{RetryTimes: 1})
+	defer closeFunc()
+
+	resp, err := client.Get("http://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || resp.Header.Get("X-Proxy-Error") != PROXY_ERROR_CONNECT_FAILED || resp.Header.Get("X-Proxy-Upstream") != addr {
+		t.Fatal(resp.StatusCode, resp.Header)
+	}
+}
+
+func TestConnectDenied(t *testing.T) {
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{Sd: &dnsOnlySd{}, RetryTimes: 1, ConnectPorts: map[int]bool{443: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodConnect, "http://127.0.0.1:22", nil)
+	req.Host = "127.0.0.1:22"
+	rw := httptest.NewRecorder()
+	forwardProxy.ServeHTTP(rw, req)
+	if rw.Code != http.StatusForbidden || rw.Header().Get("X-Proxy-Error") != PROXY_ERROR_DENIED {
+		t.Fatal(rw.Code, rw.Header())
+	}
+}
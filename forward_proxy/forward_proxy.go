@@ -1,12 +1,15 @@
 package forward_proxy
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
 )
@@ -15,197 +18,530 @@ import (
 type ForwardProxyConfig struct {
 	ListenAddr string                              // 代理监听地址
 	Sd         service_discovery.IServiceDiscovery // 服务发现
-	RetryTimes int
+	RetryTimes int                                 // CONNECT建连尝试次数
+
+	Retry        *RetryPolicy            // HTTP默认重试策略
+	ServiceRetry map[string]*RetryPolicy // 按服务名覆盖的重试策略
+	RetryBudget  *RetryBudgetConfig      // 重试预算，按服务及全局限制重试量，nil不限制
+	ServiceHedge map[string]*HedgePolicy // 开启对冲的服务
+
+	Timeout        *TimeoutPolicy            // 默认超时
+	ServiceTimeout map[string]*TimeoutPolicy // 按服务名覆盖的超时
+	TimeoutHeader  string                    // 调用方缩短总超时的请求头（毫秒），为空不支持
+	DeadlineHeader string                    // 跨跳传递剩余时间的请求头（毫秒），为空不传递
+
+	Socks5  *Socks5Config  // SOCKS5监听，nil不开启
+	Ingress *IngressConfig // 入口（反向代理）监听，nil不开启
+
+	FailStatusCodes map[int]bool // 计为节点失败的应答状态码
+	SpoolThreshold  int64        // 请求体不超过该字节数时缓存以便重试，超过则流式转发且不重试
+
+	Pool        *PoolConfig     // 上游连接池
+	H2cServices map[string]bool // 以明文HTTP/2（h2c）转发的服务，gRPC请求总是使用h2c
+
+	ProxyName               string // 代理名称，用于Via及身份header
+	DisableForwardedHeaders bool   // 不添加Via、X-Forwarded-For、Forwarded
+	IdentityHeader          string // 告知服务端sidecar及nacos节点的header名，为空不添加
+
+	ConnectPorts map[int]bool // CONNECT允许的目标端口，为空不限制
 }
 
 // 正向HTTP(S)代理
 type ForwardProxy struct {
-	server    *http.Server
-	dialer    *net.Dialer
-	transport http.Transport
-	config    *ForwardProxyConfig
+	server        *http.Server
+	ingressServer *http.Server // 入口监听，未开启为nil
+	dialer        *net.Dialer
+	pool          *upstreamPool
+	budgets       *retryBudgets             // 重试预算，未开启为nil
+	latencies     map[string]*latencyWindow // 开启对冲的服务的近期首字节耗时
+	config        *ForwardProxyConfig
+
+	mu        sync.Mutex
+	tunnels   map[*TransferPair]struct{} // 转发中的隧道
+	tunnelWg  sync.WaitGroup
+	streamWg  sync.WaitGroup                // h2c连接上进行中的请求
+	udpTables map[*udpSessionTable]struct{} // 转发中的UDP会话表
+	listeners []io.Closer                   // HTTP以外的监听，如SOCKS5、端口映射
+	isClosing bool
 }
 
-// HTTPS
-func (forwardProxy *ForwardProxy) handleHttpsRequest(rw http.ResponseWriter, req *http.Request) {
-	var err error
+// 服务发现参数
+func selectOptions(req *http.Request) *service_discovery.SelectInstanceOptions {
+	return &service_discovery.SelectInstanceOptions{
+		ServiceName: req.Host,
+		Header:      req.Header,
+		ClientIp:    clientIp(req),
+	}
+}
 
-	// 建立到服务端的TCP连接
-	var serverConn net.Conn
+// 客户端IP
+func clientIp(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// 服务发现并建连到服务端，建连失败换节点重试；服务发现失败时走域名解析连fallbackAddr
+func (forwardProxy *ForwardProxy) dialUpstream(clientCtx context.Context, options *service_discovery.SelectInstanceOptions, fallbackAddr string, timeouts *TimeoutPolicy) (serverConn net.Conn, serverIns *service_discovery.ServiceInstance, dstHost string, dialLatency time.Duration, err error) {
+	triedIds := make(map[string]bool) // 已尝试过的节点
+	options.ExcludeIDs = triedIds
 	for i := 0; i < forwardProxy.config.RetryTimes; i++ {
+		// 重试预算耗尽，快速失败
+		if i > 0 && !forwardProxy.budgets.acquire(options.ServiceName) {
+			break
+		}
 		func() { // 监听客户端侧关闭，随即中断服务端侧的请求
-			ctx, cancelFunc := context.WithCancel(context.TODO())
+			var ctx context.Context
+			var cancelFunc context.CancelFunc
+			if timeouts.Dial > 0 {
+				ctx, cancelFunc = context.WithTimeout(context.TODO(), timeouts.Dial)
+			} else {
+				ctx, cancelFunc = context.WithCancel(context.TODO())
+			}
 			defer cancelFunc()
 			go func() {
 				select {
-				case <-req.Context().Done():
+				case <-clientCtx.Done():
 					cancelFunc()
 				case <-ctx.Done():
 				}
 			}()
 
-			var dstHost string
 			var ins *service_discovery.ServiceInstance
-			// 服务发现
-			if ins, err = forwardProxy.config.Sd.SelectInstance(&service_discovery.SelectInstanceOptions{ServiceName: req.Host}); err != nil {
-				dstHost = req.Host // 服务发现失败，走域名解析
+			// 服务发现，避开已尝试过的节点
+			if ins, err = forwardProxy.config.Sd.SelectInstance(options); err != nil {
+				if fallbackAddr == "" { // 没有可以解析的地址
+					err = errNoInstance
+					return
+				}
+				dstHost = fallbackAddr // 服务发现失败，走域名解析
 			} else { // 服务发现成功
 				dstHost = fmt.Sprintf("%s:%d", ins.Ip, ins.Port)
+				triedIds[ins.ID] = true
 			}
 			// 建连到服务端
+			startTime := time.Now()
 			serverConn, err = forwardProxy.dialer.DialContext(ctx, "tcp", dstHost)
+			if err != nil { // 建连失败，立即上报
+				forwardProxy.reportOutcome(ins, classifyError(err), time.Since(startTime))
+			} else {
+				serverIns = ins
+				dialLatency = time.Since(startTime)
+			}
 		}()
-		if err == nil {
+		if err == nil || err == errNoInstance {
 			break
 		}
 	}
+	return
+}
+
+// 转发隧道直至结束，结束时上报节点结果，服务端侧中途重置/超时计为失败
+func (forwardProxy *ForwardProxy) transferTunnel(serviceName string, clientConn net.Conn, serverConn net.Conn, serverIns *service_discovery.ServiceInstance, dialLatency time.Duration, idleTimeout time.Duration) {
+	outcome := OUTCOME_CANCELED // 未能建立隧道，不计成败
+	defer func() {
+		forwardProxy.reportOutcome(serverIns, outcome, dialLatency)
+	}()
+
+	// 等待转发完成
+	transferPair := NewTransferPair(clientConn, serverConn, idleTimeout)
+	if !forwardProxy.addTunnel(transferPair) { // 代理退出中
+		return
+	}
+	defer forwardProxy.removeTunnel(transferPair)
+	transferPair.DoTransfer()
+
+	if outcome = classifyError(transferPair.ServerError()); outcome != OUTCOME_RESET && outcome != OUTCOME_TIMEOUT {
+		outcome = OUTCOME_SUCCESS
+		forwardProxy.budgets.recordSuccess(serviceName)
+	}
+}
+
+// HTTPS
+func (forwardProxy *ForwardProxy) handleHttpsRequest(rw http.ResponseWriter, req *http.Request) {
+	// 目标端口是否允许
+	if !forwardProxy.connectAllowed(req.Host) {
+		writeProxyError(rw, PROXY_ERROR_DENIED, "", fmt.Errorf("CONNECT to %s is not allowed", req.Host))
+		return
+	}
+
+	// HTTP/2的CONNECT无法接管连接，建连前拒绝
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		writeProxyError(rw, PROXY_ERROR_DENIED, "", fmt.Errorf("CONNECT over HTTP/%d is not supported", req.ProtoMajor))
+		return
+	}
+
+	timeouts := forwardProxy.timeoutPolicy(req.Host)
+
+	// 建立到服务端的TCP连接
+	serverConn, serverIns, dstHost, dialLatency, err := forwardProxy.dialUpstream(req.Context(), selectOptions(req), req.Host, timeouts)
 	if err == nil {
 		defer serverConn.Close()
-	} else {
-		return // 连接失败
+	} else { // 连接失败，此时尚未接管连接，可以回复错误
+		if req.Context().Err() == nil {
+			writeProxyError(rw, classifyProxyError(err), dstHost, err)
+		}
+		return
 	}
 
 	// 接管客户端侧的TCP连接
 	var clientConn net.Conn
-	if hijacker, ok := rw.(http.Hijacker); ok {
-		if clientConn, _, err = hijacker.Hijack(); err != nil {
-			return
-		}
-		defer clientConn.Close() // 接管成功，确保离开前关闭
-	} else { // 接管失败
+	if clientConn, _, err = hijacker.Hijack(); err != nil {
+		forwardProxy.reportOutcome(serverIns, OUTCOME_CANCELED, dialLatency)
 		return
 	}
+	defer clientConn.Close() // 接管成功，确保离开前关闭
 
 	// 回复客户端HTTPS握手
 	if _, err = clientConn.Write([]byte("HTTP/1.0 200 Connection Established\r\n\r\n")); err != nil {
+		forwardProxy.reportOutcome(serverIns, OUTCOME_CANCELED, dialLatency)
 		return
 	}
 
-	// 等待转发完成
-	var transferPair = NewTransferPair(clientConn, serverConn)
-	transferPair.DoTransfer()
+	forwardProxy.transferTunnel(req.Host, clientConn, serverConn, serverIns, dialLatency, timeouts.TunnelIdle)
 }
 
-func (forwardProxy *ForwardProxy) transferHttpRequest(req *http.Request) (resp *http.Response, respBody []byte, err error) {
-	rawHost := req.Host
-
-	// 服务发现
-	var ins *service_discovery.ServiceInstance
-	if ins, err = forwardProxy.config.Sd.SelectInstance(&service_discovery.SelectInstanceOptions{ServiceName: req.Host}); err == nil {
-		req.URL.Host = fmt.Sprintf("%s:%d", ins.Ip, ins.Port)
-		req.Header.Set("Host", rawHost)
+// 登记隧道，代理退出中则拒绝
+func (forwardProxy *ForwardProxy) addTunnel(transferPair *TransferPair) bool {
+	forwardProxy.mu.Lock()
+	defer forwardProxy.mu.Unlock()
+	if forwardProxy.isClosing {
+		return false
 	}
+	forwardProxy.tunnels[transferPair] = struct{}{}
+	forwardProxy.tunnelWg.Add(1)
+	return true
+}
 
-	// 发送请求
-	if resp, err = forwardProxy.transport.RoundTrip(req); err != nil {
-		return
-	}
-	// 读取应答
-	defer resp.Body.Close()
-	if respBody, err = ioutil.ReadAll(resp.Body); err != nil {
-		return
+// 注销隧道
+func (forwardProxy *ForwardProxy) removeTunnel(transferPair *TransferPair) {
+	forwardProxy.mu.Lock()
+	delete(forwardProxy.tunnels, transferPair)
+	forwardProxy.mu.Unlock()
+	forwardProxy.tunnelWg.Done()
+}
+
+// 服务发现，避开已尝试过的节点，Host头保持不变
+func (forwardProxy *ForwardProxy) selectUpstream(req *http.Request, excludeIds map[string]bool) (ins *service_discovery.ServiceInstance) {
+	options := selectOptions(req)
+	options.ExcludeIDs = excludeIds
+	var err error
+	if ins, err = forwardProxy.config.Sd.SelectInstance(options); err == nil {
+		req.URL.Host = fmt.Sprintf("%s:%d", ins.Ip, ins.Port)
+	} else { // 服务发现失败，走域名解析
+		ins = nil
 	}
+	forwardProxy.setIdentityHeader(req, ins)
 	return
 }
 
-// 拷贝应答
-func (forwardProxy *ForwardProxy) copyResponse(dst http.ResponseWriter, src *http.Response, body []byte) {
-	// 拷贝header
+// 拷贝应答：header和状态码，然后流式拷贝body
+func (forwardProxy *ForwardProxy) copyResponse(dst http.ResponseWriter, src *http.Response) (readErr error, writeErr error) {
+	// 拷贝header，去掉逐跳header
+	forwardProxy.rewriteResponseHeader(src)
 	for key, values := range src.Header {
 		for _, v := range values {
 			dst.Header().Add(key, v)
 		}
 	}
 	dst.WriteHeader(src.StatusCode) // 状态码
-	dst.Write(body)                 // 消息体
+	readErr, writeErr = copyResponseBody(dst, src.Body, needFlush(src))
+	// body读完后trailer才可用（如gRPC的grpc-status）
+	for key, values := range src.Trailer {
+		for _, v := range values {
+			dst.Header().Add(http.TrailerPrefix+key, v)
+		}
+	}
+	return
+}
+
+// 一次HTTP转发，在重试与对冲之间共享
+type httpExchange struct {
+	req      *http.Request
+	body     *requestBody
+	retry    *RetryPolicy
+	timeout  *TimeoutPolicy
+	ctx      context.Context // 客户端离开或总超时后结束
+	triedIds map[string]bool // 已尝试过的节点
 }
 
 // HTTP
 func (forwardProxy *ForwardProxy) handleHttpRequest(rw http.ResponseWriter, req *http.Request) {
 	var err error
 
-	// 读取body
-	var reqBody []byte
-	if req.Body != nil {
-		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
-			return
+	exchange := &httpExchange{
+		req:      req,
+		retry:    forwardProxy.retryPolicy(req.Host),
+		timeout:  forwardProxy.timeoutPolicy(req.Host),
+		triedIds: make(map[string]bool),
+	}
+	policy := exchange.retry
+
+	// 读取body，小body缓存起来以便重试，大body流式转发
+	if exchange.body, err = spoolRequestBody(req, forwardProxy.config.SpoolThreshold); err != nil {
+		if req.Context().Err() == nil {
+			writeProxyError(rw, PROXY_ERROR_BAD_REQUEST, "", err)
 		}
+		return
 	}
 
-	// 客户端已离开?
-	var clientLeave bool
+	// 整个请求的截止时间
+	var cancelFunc context.CancelFunc
+	if total := forwardProxy.totalTimeout(req, exchange.timeout); total > 0 {
+		exchange.ctx, cancelFunc = context.WithTimeout(req.Context(), total)
+	} else {
+		exchange.ctx, cancelFunc = context.WithCancel(req.Context())
+	}
+	defer cancelFunc()
 
-	// 重试3次
-	for i := 0; i < forwardProxy.config.RetryTimes; i++ {
-		// 应答
-		var resp *http.Response
-		var respBody []byte
+	// 最后一次尝试的服务端
+	var upstream string
+
+	attempt := 1
+	for ; ; attempt++ {
+		// 重试前退避
+		if attempt > 1 && !sleepContext(exchange.ctx, policy.backoff(attempt-1)) {
+			if req.Context().Err() != nil {
+				return // 客户端离开
+			}
+			err = exchange.ctx.Err() // 总超时
+			break
+		}
+		// 次数用尽，不能再重试
+		lastAttempt := attempt >= policy.MaxAttempts
+
+		// 应答已开始转发
+		var done bool
+		// 本次失败可以重试
+		var retry bool
 
 		// 转发请求
 		func() {
-			// 监听客户端侧关闭，随即中断服务端侧的请求
-			ctx, cancelFunc := context.WithCancel(context.TODO())
-			defer cancelFunc()
-			go func() {
-				select {
-				case <-req.Context().Done():
-					clientLeave = true
-					cancelFunc()
-				case <-ctx.Done():
+			// 发送请求到服务端，获取应答头，必要时对冲
+			result := forwardProxy.hedgedRoundTrip(exchange)
+			defer result.cancelFunc()
+			upstream = result.upstream
+			if err = result.err; err != nil { // 失败已上报
+				// 流式body只能发送1次，仅在建连失败、body尚未发送时重试
+				outcome := classifyError(err)
+				resendable := exchange.body.replayable || outcome == OUTCOME_DIAL_FAIL && !exchange.body.streamSent()
+				retry = !lastAttempt && resendable && policy.retryableError(req.Method, outcome) && forwardProxy.budgets.acquire(req.Host)
+				return
+			}
+			resp, ins, latency := result.resp, result.ins, result.latency
+			defer resp.Body.Close()
+
+			// 状态码可重试且预算充足，丢弃本次应答
+			if !lastAttempt && exchange.body.replayable && policy.retryableStatus(req.Method, resp.StatusCode) && forwardProxy.budgets.acquire(req.Host) {
+				forwardProxy.reportOutcome(ins, forwardProxy.classifyHttpOutcome(resp, nil), latency)
+				retry = true
+				return
+			}
+
+			// 请求成功，流式转发应答
+			done = true
+			rw.Header().Set("X-Proxy-Attempts", strconv.Itoa(attempt))
+			readErr, writeErr := forwardProxy.copyResponse(rw, resp)
+			outcome := forwardProxy.classifyHttpOutcome(resp, nil)
+			if writeErr != nil || req.Context().Err() != nil { // 客户端离开
+				outcome = OUTCOME_CANCELED
+			} else if readErr != nil { // 服务端中途出错
+				if outcome = classifyError(readErr); outcome == OUTCOME_CANCELED {
+					outcome = OUTCOME_RESET
 				}
-			}()
-			// 构造转发请求
-			remoteReq := req.Clone(ctx)
-			if reqBody == nil {
-				remoteReq.Body = nil
-			} else {
-				remoteReq.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
 			}
-			// 发送请求到服务端，获取应答
-			resp, respBody, err = forwardProxy.transferHttpRequest(remoteReq)
+			forwardProxy.reportOutcome(ins, outcome, latency)
+			if outcome == OUTCOME_SUCCESS {
+				forwardProxy.budgets.recordSuccess(req.Host)
+				forwardProxy.observeLatency(req.Host, latency)
+			}
 		}()
 
-		// 客户端离开了, 那么就这样吧
-		if clientLeave {
+		// 客户端离开了, 或应答已转发, 那么就这样吧
+		if req.Context().Err() != nil || done {
 			return
 		}
-		// 服务端侧有错误, 继续重试
-		if err != nil {
-			continue
+		// 服务端侧有错误, 可重试且预算充足则继续
+		if !retry {
+			break
 		}
-		// 请求成功，转发应答
-		forwardProxy.copyResponse(rw, resp, respBody)
-		return
 	}
-	// 所有重试均失败
-	rw.WriteHeader(500)
+	// 所有尝试均失败
+	rw.Header().Set("X-Proxy-Attempts", strconv.Itoa(attempt))
+	if isGrpcRequest(req) {
+		writeGrpcError(rw, classifyProxyError(err), upstream, err)
+	} else {
+		writeProxyError(rw, classifyProxyError(err), upstream, err)
+	}
+}
+
+// CONNECT的目标端口是否允许
+func (forwardProxy *ForwardProxy) connectAllowed(host string) bool {
+	if len(forwardProxy.config.ConnectPorts) == 0 {
+		return true
+	}
+	_, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return false
+	}
+	nport, err := strconv.Atoi(port)
+	return err == nil && forwardProxy.config.ConnectPorts[nport]
 }
 
 // 请求入口
 func (forwardProxy *ForwardProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if req.Method == http.MethodConnect { // HTTPS
 		forwardProxy.handleHttpsRequest(rw, req)
+	} else if isUpgradeRequest(req) { // WebSocket等协议升级
+		forwardProxy.handleUpgradeRequest(rw, req)
 	} else { // HTTP
 		forwardProxy.handleHttpRequest(rw, req)
 	}
 }
 
-// 启动代理
-func (forwardProxy *ForwardProxy) Run() {
-	forwardProxy.server.ListenAndServe()
+// 启动代理，Shutdown后返回nil
+func (forwardProxy *ForwardProxy) Run() (err error) {
+	if err = forwardProxy.server.ListenAndServe(); err == http.ErrServerClosed {
+		err = nil
+	}
+	return
+}
+
+// 监听HTTP以外的端口，代理退出时关闭
+func (forwardProxy *ForwardProxy) listen(network string, addr string) (listener net.Listener, err error) {
+	forwardProxy.mu.Lock()
+	defer forwardProxy.mu.Unlock()
+	if forwardProxy.isClosing {
+		err = errors.New("代理退出中")
+		return
+	}
+	if listener, err = net.Listen(network, addr); err != nil {
+		return
+	}
+	forwardProxy.listeners = append(forwardProxy.listeners, listener)
+	return
+}
+
+// 同listen，用于UDP等无连接协议
+func (forwardProxy *ForwardProxy) listenPacket(network string, addr string) (packetConn net.PacketConn, err error) {
+	forwardProxy.mu.Lock()
+	defer forwardProxy.mu.Unlock()
+	if forwardProxy.isClosing {
+		err = errors.New("代理退出中")
+		return
+	}
+	if packetConn, err = net.ListenPacket(network, addr); err != nil {
+		return
+	}
+	forwardProxy.listeners = append(forwardProxy.listeners, packetConn)
+	return
+}
+
+// 接受TCP连接直至监听被关闭，Shutdown后返回nil
+func (forwardProxy *ForwardProxy) serveTcp(listener net.Listener, handler func(conn net.Conn)) (err error) {
+	for {
+		var conn net.Conn
+		if conn, err = listener.Accept(); err != nil {
+			forwardProxy.mu.Lock()
+			isClosing := forwardProxy.isClosing
+			forwardProxy.mu.Unlock()
+			if isClosing {
+				err = nil
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() { // 如文件描述符耗尽，稍后再试
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		go handler(conn)
+	}
+}
+
+// 优雅关闭：停止监听，等待进行中的HTTP请求与隧道结束，超时则强制关闭隧道
+func (forwardProxy *ForwardProxy) Shutdown(ctx context.Context) (err error) {
+	forwardProxy.mu.Lock()
+	forwardProxy.isClosing = true
+	for _, listener := range forwardProxy.listeners {
+		listener.Close()
+	}
+	forwardProxy.mu.Unlock()
+
+	// 等待HTTP请求
+	err = forwardProxy.server.Shutdown(ctx)
+	if forwardProxy.ingressServer != nil {
+		if ingressErr := forwardProxy.ingressServer.Shutdown(ctx); err == nil {
+			err = ingressErr
+		}
+	}
+
+	// 等待隧道及h2c连接上的请求
+	tunnelsDone := make(chan byte)
+	go func() {
+		forwardProxy.tunnelWg.Wait()
+		forwardProxy.streamWg.Wait()
+		close(tunnelsDone)
+	}()
+	select {
+	case <-tunnelsDone:
+	case <-ctx.Done():
+		forwardProxy.mu.Lock()
+		for transferPair := range forwardProxy.tunnels {
+			transferPair.closeOnError(ctx.Err())
+		}
+		forwardProxy.mu.Unlock()
+		err = ctx.Err()
+	}
+	return
 }
 
 // 新建HTTP正向代理
 func NewForwardProxy(forwardProxyConfig *ForwardProxyConfig) (forwardProxy *ForwardProxy, err error) {
 	forwardProxy = &ForwardProxy{}
 	forwardProxy.dialer = &net.Dialer{}
-	forwardProxy.transport = http.Transport{DisableKeepAlives: true}
 	forwardProxy.config = forwardProxyConfig
+	if forwardProxyConfig.Pool == nil {
+		forwardProxyConfig.Pool = &PoolConfig{MaxIdleConnsPerHost: 32, IdleConnTimeout: 90 * time.Second}
+	}
+	if forwardProxyConfig.Retry == nil {
+		forwardProxyConfig.Retry = &RetryPolicy{
+			MaxAttempts: forwardProxyConfig.RetryTimes,
+			Methods:     DefaultRetryMethods(),
+			RetryOn:     map[string]bool{RETRY_ON_CONNECT_FAILURE: true, RETRY_ON_RESET: true, RETRY_ON_TIMEOUT: true},
+		}
+	}
+	forwardProxy.latencies = make(map[string]*latencyWindow)
+	for serviceName := range forwardProxyConfig.ServiceHedge {
+		forwardProxy.latencies[serviceName] = newLatencyWindow()
+	}
+	if forwardProxyConfig.Timeout == nil {
+		forwardProxyConfig.Timeout = &TimeoutPolicy{Dial: 5 * time.Second, TunnelIdle: 5 * time.Minute}
+	}
+	if forwardProxyConfig.RetryBudget != nil {
+		forwardProxy.budgets = newRetryBudgets(forwardProxyConfig.RetryBudget)
+	}
+	forwardProxy.pool = newUpstreamPool(forwardProxyConfig.Pool, forwardProxy.dialer)
+	// 节点下线或熔断时淘汰其连接与UDP会话
+	forwardProxyConfig.Sd.OnInstanceDown(func(instance *service_discovery.ServiceInstance) {
+		forwardProxy.pool.evict(fmt.Sprintf("%s:%d", instance.Ip, instance.Port))
+		forwardProxy.evictUdpSessions(instance)
+	})
+	forwardProxy.tunnels = make(map[*TransferPair]struct{})
+	forwardProxy.udpTables = make(map[*udpSessionTable]struct{})
 
-	// 创建HTTP服务
+	// 创建HTTP服务，同时接受明文HTTP/2（h2c）
 	forwardProxy.server = &http.Server{
 		Addr:    forwardProxyConfig.ListenAddr,
-		Handler: forwardProxy,
+		Handler: forwardProxy.h2cHandler(forwardProxy),
+	}
+	if forwardProxyConfig.Ingress != nil {
+		forwardProxy.ingressServer = &http.Server{
+			Addr:    forwardProxyConfig.Ingress.ListenAddr,
+			Handler: forwardProxy.h2cHandler(http.HandlerFunc(forwardProxy.handleIngressRequest)),
+		}
 	}
 	return
 }
@@ -0,0 +1,91 @@
+package forward_proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// gRPC状态码
+const (
+	GRPC_STATUS_DEADLINE_EXCEEDED = 4
+	GRPC_STATUS_PERMISSION_DENIED = 7
+	GRPC_STATUS_UNAVAILABLE       = 14
+)
+
+// 代理错误对应的gRPC状态码
+var proxyErrorGrpcStatus = map[string]int{
+	PROXY_ERROR_NO_INSTANCE:      GRPC_STATUS_UNAVAILABLE,
+	PROXY_ERROR_CONNECT_FAILED:   GRPC_STATUS_UNAVAILABLE,
+	PROXY_ERROR_UPSTREAM_RESET:   GRPC_STATUS_UNAVAILABLE,
+	PROXY_ERROR_UPSTREAM_TIMEOUT: GRPC_STATUS_DEADLINE_EXCEEDED,
+	PROXY_ERROR_DENIED:           GRPC_STATUS_PERMISSION_DENIED,
+	PROXY_ERROR_SHUTTING_DOWN:    GRPC_STATUS_UNAVAILABLE,
+}
+
+// 接受明文HTTP/2（h2c）的处理器。h2c连接被接管后http.Server.Shutdown不再等待其上的请求，因此自行登记
+func (forwardProxy *ForwardProxy) h2cHandler(handler http.Handler) http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor == 2 {
+			if !forwardProxy.addStream() {
+				if isGrpcRequest(req) {
+					writeGrpcError(rw, PROXY_ERROR_SHUTTING_DOWN, "", nil)
+				} else {
+					writeProxyError(rw, PROXY_ERROR_SHUTTING_DOWN, "", nil)
+				}
+				return
+			}
+			defer forwardProxy.streamWg.Done()
+		}
+		handler.ServeHTTP(rw, req)
+	}), &http2.Server{})
+}
+
+// 登记h2c连接上的请求，代理退出中则拒绝
+func (forwardProxy *ForwardProxy) addStream() bool {
+	forwardProxy.mu.Lock()
+	defer forwardProxy.mu.Unlock()
+	if forwardProxy.isClosing {
+		return false
+	}
+	forwardProxy.streamWg.Add(1)
+	return true
+}
+
+// 是否gRPC请求
+func isGrpcRequest(req *http.Request) bool {
+	return req.ProtoMajor == 2 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpc-message按gRPC规范百分号编码
+func encodeGrpcMessage(message string) string {
+	var builder strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c >= 0x20 && c <= 0x7e && c != '%' {
+			builder.WriteByte(c)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", c)
+		}
+	}
+	return builder.String()
+}
+
+// 以gRPC的Trailers-Only应答回复代理错误，gRPC客户端只认grpc-status
+func writeGrpcError(rw http.ResponseWriter, kind string, upstream string, err error) {
+	message := "proxy error: " + kind
+	if err != nil {
+		message += ": " + err.Error()
+	}
+	rw.Header().Set("Content-Type", "application/grpc")
+	rw.Header().Set("Grpc-Status", strconv.Itoa(proxyErrorGrpcStatus[kind]))
+	rw.Header().Set("Grpc-Message", encodeGrpcMessage(message))
+	rw.Header().Set("X-Proxy-Error", kind)
+	if upstream != "" {
+		rw.Header().Set("X-Proxy-Upstream", upstream)
+	}
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,217 @@
+package forward_proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// 以h2c prior knowledge方式访问代理
+func newH2cClient(proxyAddr string) *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network string, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+			return net.Dial(network, proxyAddr)
+		},
+	}}
+}
+
+func TestGrpcForward(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 || req.Header.Get("Te") != "trailers" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		rw.Header().Set("Content-Type", "application/grpc")
+		rw.Header().Set("Trailer", "Grpc-Status")
+		rw.Write(body)
+		rw.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{Sd: &dnsOnlySd{}, RetryTimes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(forwardProxy.server.Handler)
+	defer proxyServer.Close()
+	client := newH2cClient(proxyServer.Listener.Addr().String())
+
+	req, _ := http.NewRequest(http.MethodPost, backend.URL+"/echo.Echo/Say", strings.NewReader("ping"))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ping" || resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Fatal(resp.StatusCode, string(body), resp.Trailer)
+	}
+
+	// 代理错误映射为gRPC状态码
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+	req, _ = http.NewRequest(http.MethodPost, "http://"+addr+"/echo.Echo/Say", strings.NewReader("ping"))
+	req.Header.Set("Content-Type", "application/grpc")
+	if resp, err = client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Grpc-Status") != "14" || resp.Header.Get("X-Proxy-Error") != PROXY_ERROR_CONNECT_FAILED {
+		t.Fatal(resp.StatusCode, resp.Header)
+	}
+}
+
+func TestH2cOnlyForGrpc(t *testing.T) {
+	// 只支持HTTP/1.1的服务端
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(req.Proto))
+	}))
+	defer backend.Close()
+
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{Sd: &dnsOnlySd{}, RetryTimes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(forwardProxy.server.Handler)
+	defer proxyServer.Close()
+	client := newH2cClient(proxyServer.Listener.Addr().String())
+
+	// 非gRPC的HTTP/2请求以HTTP/1.1转发
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "HTTP/1.1" {
+		t.Fatal(resp.StatusCode, string(body))
+	}
+}
+
+func TestH2cEvict(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(req.Proto))
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	pool := newUpstreamPool(&PoolConfig{IdleConnTimeout: time.Minute}, &net.Dialer{})
+	addr := backend.Listener.Addr().String()
+	req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+	req = req.WithContext(withUpstreamContext(req.Context(), &TimeoutPolicy{Dial: time.Second}, "", true))
+	resp, err := pool.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "HTTP/2.0" {
+		t.Fatal(string(body))
+	}
+
+	// 同一节点复用h2c连接，淘汰后关闭
+	ht := pool.get(addr)
+	ht.h2cMu.Lock()
+	cc := ht.h2cConn
+	ht.h2cMu.Unlock()
+	if cc == nil {
+		t.Fatal("h2c连接未复用")
+	}
+	pool.evict(addr)
+	deadline := time.Now().Add(3 * time.Second)
+	for cc.CanTakeNewRequest() {
+		if time.Now().After(deadline) {
+			t.Fatal("h2c连接未关闭")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestH2cShutdown(t *testing.T) {
+	received := make(chan byte)
+	release := make(chan byte)
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(received)
+		<-release
+		rw.Header().Set("Content-Type", "application/grpc")
+		rw.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{Sd: &dnsOnlySd{}, RetryTimes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(forwardProxy.server.Handler)
+	defer proxyServer.Close()
+	client := newH2cClient(proxyServer.Listener.Addr().String())
+
+	newReq := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, backend.URL+"/echo.Echo/Say", strings.NewReader("ping"))
+		req.Header.Set("Content-Type", "application/grpc")
+		return req
+	}
+	respChan := make(chan *http.Response, 1)
+	go func() {
+		resp, _ := client.Do(newReq())
+		respChan <- resp
+	}()
+	<-received
+
+	// 进行中的gRPC请求未结束，Shutdown等待至超时
+	ctx, cancelFunc := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancelFunc()
+	if err = forwardProxy.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	// 退出中拒绝新请求
+	resp, err := client.Do(newReq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Grpc-Status") != "14" || resp.Header.Get("X-Proxy-Error") != PROXY_ERROR_SHUTTING_DOWN {
+		t.Fatal(resp.Header)
+	}
+
+	close(release)
+	if resp = <-respChan; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(resp)
+	}
+	resp.Body.Close()
+}
+
+func TestH2cConnectDenied(t *testing.T) {
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{Sd: &dnsOnlySd{}, RetryTimes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(forwardProxy.server.Handler)
+	defer proxyServer.Close()
+	client := newH2cClient(proxyServer.Listener.Addr().String())
+
+	// HTTP/2的CONNECT无法建立隧道，明确拒绝
+	req, _ := http.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || resp.Header.Get("X-Proxy-Error") != PROXY_ERROR_DENIED {
+		t.Fatal(resp.StatusCode, resp.Header)
+	}
+}
@@ -0,0 +1,106 @@
+package forward_proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 逐跳header（RFC 7230 6.1），不能被代理转发
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection", // 非标准，但客户端走代理时常见
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// 删除逐跳header，包括Connection中列出的header
+func removeHopHeaders(header http.Header) {
+	for _, value := range header["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// 追加Via
+func addVia(header http.Header, protoMajor int, protoMinor int, proxyName string) {
+	header.Add("Via", fmt.Sprintf("%d.%d %s", protoMajor, protoMinor, proxyName))
+}
+
+// 改写转发请求的header
+func (forwardProxy *ForwardProxy) rewriteRequestHeader(remoteReq *http.Request, req *http.Request) {
+	removeHopHeaders(remoteReq.Header)
+	// gRPC要求携带TE: trailers
+	if strings.Contains(strings.ToLower(strings.Join(req.Header["Te"], ",")), "trailers") {
+		remoteReq.Header.Set("Te", "trailers")
+	}
+	// 只对本代理有效的超时header
+	if forwardProxy.config.TimeoutHeader != "" {
+		remoteReq.Header.Del(forwardProxy.config.TimeoutHeader)
+	}
+	if forwardProxy.config.DisableForwardedHeaders {
+		return
+	}
+
+	addVia(remoteReq.Header, req.ProtoMajor, req.ProtoMinor, forwardProxy.config.ProxyName)
+
+	ip := clientIp(req)
+	// X-Forwarded-For，追加客户端IP
+	if prior := remoteReq.Header.Get("X-Forwarded-For"); prior != "" {
+		remoteReq.Header.Set("X-Forwarded-For", prior+", "+ip)
+	} else {
+		remoteReq.Header.Set("X-Forwarded-For", ip)
+	}
+	// Forwarded（RFC 7239），IPv6及带端口的host含":"，需要加引号
+	forIp := ip
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		forIp = fmt.Sprintf("\"[%s]\"", ip)
+	}
+	host := originalHost(req)
+	if strings.Contains(host, ":") {
+		host = fmt.Sprintf("\"%s\"", host)
+	}
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	forwarded := fmt.Sprintf("for=%s;host=%s;proto=%s", forIp, host, proto)
+	if prior := remoteReq.Header.Get("Forwarded"); prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	remoteReq.Header.Set("Forwarded", forwarded)
+}
+
+// 告知服务端处理本次请求的sidecar及nacos节点
+func (forwardProxy *ForwardProxy) setIdentityHeader(remoteReq *http.Request, ins *service_discovery.ServiceInstance) {
+	if forwardProxy.config.IdentityHeader == "" {
+		return
+	}
+	instanceId := ""
+	if ins != nil {
+		instanceId = ins.ID
+	}
+	remoteReq.Header.Set(forwardProxy.config.IdentityHeader, fmt.Sprintf("proxy=%s; instance=%s", forwardProxy.config.ProxyName, instanceId))
+}
+
+// 改写应答的header
+func (forwardProxy *ForwardProxy) rewriteResponseHeader(resp *http.Response) {
+	removeHopHeaders(resp.Header)
+	if !forwardProxy.config.DisableForwardedHeaders {
+		addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor, forwardProxy.config.ProxyName)
+	}
+}
@@ -0,0 +1,69 @@
+package forward_proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoveHopHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Connection", "keep-alive, X-Hop")
+	header.Set("X-Hop", "1")
+	header.Set("Proxy-Connection", "keep-alive")
+	header.Set("Te", "trailers")
+	header.Set("X-Keep", "1")
+	removeHopHeaders(header)
+	for _, name := range []string{"Connection", "X-Hop", "Proxy-Connection", "Te"} {
+		if header.Get(name) != "" {
+			t.Fatal(name)
+		}
+	}
+	if header.Get("X-Keep") != "1" {
+		t.Fatal(header)
+	}
+}
+
+func TestForwardHeaders(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received = req.Header.Clone()
+		rw.Header().Set("Connection", "X-Secret")
+		rw.Header().Set("X-Secret", "1")
+	}))
+	defer backend.Close()
+
+	_, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{ProxyName: "sidecar", IdentityHeader: "X-Proxy-Identity"})
+	defer closeFunc()
+
+	req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// 请求方向
+	if received.Get("Proxy-Connection") != "" {
+		t.Fatal("Proxy-Connection泄漏")
+	}
+	if received.Get("X-Forwarded-For") != "10.0.0.1, 127.0.0.1" {
+		t.Fatal(received.Get("X-Forwarded-For"))
+	}
+	if received.Get("Via") != "1.1 sidecar" {
+		t.Fatal(received)
+	}
+	if forwarded := received.Get("Forwarded"); forwarded != fmt.Sprintf("for=127.0.0.1;host=\"%s\";proto=http", backend.Listener.Addr()) {
+		t.Fatal(forwarded)
+	}
+	if received.Get("X-Proxy-Identity") != "proxy=sidecar; instance=" {
+		t.Fatal(received.Get("X-Proxy-Identity"))
+	}
+	// 应答方向
+	if resp.Header.Get("X-Secret") != "" || resp.Header.Get("Via") != "1.1 sidecar" {
+		t.Fatal(resp.Header)
+	}
+}
@@ -0,0 +1,206 @@
+package forward_proxy
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+var (
+	hedgedRequests = expvar.NewInt("proxy_hedged_requests") // 发出的对冲请求数
+	hedgeWins      = expvar.NewInt("proxy_hedge_wins")      // 对冲请求先于原请求返回的次数
+)
+
+const (
+	HEDGE_LATENCY_SAMPLES     = 128 // 统计分位数的样本窗口
+	HEDGE_LATENCY_MIN_SAMPLES = 20  // 样本不足时不对冲
+)
+
+// 对冲策略：原请求超过延迟仍未返回应答头，则向另一个节点再发1次，先返回者胜出
+type HedgePolicy struct {
+	Delay      time.Duration // 固定延迟，为0则取近期首字节耗时的分位数
+	Percentile float64       // 分位数，如95
+}
+
+// 近期首字节耗时
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration // 环形缓冲
+	next    int
+}
+
+func newLatencyWindow() *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, 0, HEDGE_LATENCY_SAMPLES)}
+}
+
+func (window *latencyWindow) add(latency time.Duration) {
+	window.mu.Lock()
+	defer window.mu.Unlock()
+	if len(window.samples) < HEDGE_LATENCY_SAMPLES {
+		window.samples = append(window.samples, latency)
+	} else {
+		window.samples[window.next] = latency
+	}
+	window.next = (window.next + 1) % HEDGE_LATENCY_SAMPLES
+}
+
+// 分位数，样本不足返回false
+func (window *latencyWindow) percentile(percentile float64) (latency time.Duration, ok bool) {
+	window.mu.Lock()
+	sorted := append([]time.Duration(nil), window.samples...)
+	window.mu.Unlock()
+	if len(sorted) < HEDGE_LATENCY_MIN_SAMPLES {
+		return
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(float64(len(sorted)-1) * percentile / 100)
+	latency, ok = sorted[index], true
+	return
+}
+
+// 记录服务的首字节耗时，仅对开启对冲的服务
+func (forwardProxy *ForwardProxy) observeLatency(serviceName string, latency time.Duration) {
+	if window, exist := forwardProxy.latencies[serviceName]; exist {
+		window.add(latency)
+	}
+}
+
+// 本次请求的对冲延迟，不对冲返回false
+func (forwardProxy *ForwardProxy) hedgeDelay(exchange *httpExchange) (delay time.Duration, ok bool) {
+	req := exchange.req
+	hedge, exist := forwardProxy.config.ServiceHedge[req.Host]
+	// 仅对幂等方法、可重放的body对冲
+	if !exist || !exchange.body.replayable || !exchange.retry.Methods[req.Method] {
+		return
+	}
+	if hedge.Delay > 0 {
+		return hedge.Delay, true
+	}
+	return forwardProxy.latencies[req.Host].percentile(hedge.Percentile)
+}
+
+// 一路请求的结果
+type attemptResult struct {
+	resp       *http.Response
+	ins        *service_discovery.ServiceInstance
+	upstream   string        // 服务端地址
+	latency    time.Duration // 首字节耗时
+	err        error
+	hedged     bool               // 是否对冲请求
+	cancelFunc context.CancelFunc // 中断该路请求，应答读完后也需调用
+}
+
+// 发起一路请求：同步选择节点，异步发送，结果写入results；对冲请求没有未尝试过的节点或预算不足时返回nil
+func (forwardProxy *ForwardProxy) startAttempt(exchange *httpExchange, results chan<- *attemptResult, hedged bool) (result *attemptResult) {
+	req := exchange.req
+	// 客户端离开或总超时，随即中断服务端侧的请求
+	var ctx context.Context
+	var cancelFunc context.CancelFunc
+	if exchange.retry.PerTryTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(exchange.ctx, exchange.retry.PerTryTimeout)
+	} else {
+		ctx, cancelFunc = context.WithCancel(exchange.ctx)
+	}
+	// 构造转发请求，连接池按服务的超时策略建连
+	serverName, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		serverName = req.Host
+	}
+	h2c := isGrpcRequest(req) || forwardProxy.config.H2cServices[req.Host]
+	remoteReq := req.Clone(withUpstreamContext(ctx, exchange.timeout, serverName, h2c))
+	if remoteReq.URL.Host == "" { // HTTP/2请求（如gRPC）不带绝对URL
+		remoteReq.URL.Scheme = "http"
+		remoteReq.URL.Host = req.Host
+	}
+	exchange.body.attachTo(remoteReq)
+	forwardProxy.rewriteRequestHeader(remoteReq, req)
+	forwardProxy.propagateDeadline(remoteReq)
+
+	ins := forwardProxy.selectUpstream(remoteReq, exchange.triedIds)
+	// 节点都尝试过时服务发现不再避开，对冲发往同一节点只会加重其负载
+	if hedged && (ins == nil || exchange.triedIds[ins.ID] || !forwardProxy.budgets.acquire(req.Host)) {
+		forwardProxy.reportOutcome(ins, OUTCOME_CANCELED, 0)
+		cancelFunc()
+		return nil
+	}
+	if ins != nil {
+		exchange.triedIds[ins.ID] = true
+	}
+	result = &attemptResult{ins: ins, upstream: remoteReq.URL.Host, hedged: hedged, cancelFunc: cancelFunc}
+
+	// 发送请求到服务端，获取应答头
+	go func() {
+		startTime := time.Now()
+		resp, err := forwardProxy.roundTripWithHeaderTimeout(remoteReq, exchange.timeout.ResponseHeader, cancelFunc)
+		result.latency = time.Since(startTime)
+		result.resp, result.err = resp, err
+		if err != nil { // 失败立即上报
+			forwardProxy.reportOutcome(ins, classifyError(err), result.latency)
+			cancelFunc()
+		}
+		results <- result
+	}()
+	return
+}
+
+// 发送请求，必要时对冲，返回最先成功的一路，其余的被中断
+func (forwardProxy *ForwardProxy) hedgedRoundTrip(exchange *httpExchange) (result *attemptResult) {
+	results := make(chan *attemptResult, 2)
+	attempts := []*attemptResult{forwardProxy.startAttempt(exchange, results, false)}
+
+	var hedgeTimer <-chan time.Time
+	if delay, ok := forwardProxy.hedgeDelay(exchange); ok {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedgeTimer = timer.C
+	}
+
+	pending := 1
+	for {
+		select {
+		case <-hedgeTimer: // 原请求太慢，有未尝试过的节点且预算充足则对冲
+			hedgeTimer = nil
+			if hedged := forwardProxy.startAttempt(exchange, results, true); hedged != nil {
+				attempts = append(attempts, hedged)
+				pending++
+				hedgedRequests.Add(1)
+			}
+		case result = <-results:
+			pending--
+			if result.err != nil && pending > 0 { // 等待另一路
+				continue
+			}
+			if result.hedged && result.err == nil {
+				hedgeWins.Add(1)
+			}
+			// 中断落后的一路
+			if pending > 0 {
+				for _, attempt := range attempts {
+					if attempt != result {
+						attempt.cancelFunc()
+					}
+				}
+				go forwardProxy.discardAttempts(results, pending)
+			}
+			return
+		}
+	}
+}
+
+// 丢弃落后一路的应答
+func (forwardProxy *ForwardProxy) discardAttempts(results <-chan *attemptResult, pending int) {
+	for i := 0; i < pending; i++ {
+		result := <-results
+		if result.err == nil {
+			result.resp.Body.Close()
+			forwardProxy.reportOutcome(result.ins, OUTCOME_CANCELED, result.latency)
+			result.cancelFunc()
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package forward_proxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 固定节点列表，按顺序选出未尝试过的节点
+type staticSd struct {
+	service_discovery.IServiceDiscovery
+	mu        sync.Mutex
+	instances []*service_discovery.ServiceInstance
+	released  int
+}
+
+func (sd *staticSd) SelectInstance(options *service_discovery.SelectInstanceOptions) (instance *service_discovery.ServiceInstance, err error) {
+	for _, ins := range sd.instances {
+		if !options.ExcludeIDs[ins.ID] {
+			return ins, nil
+		}
+	}
+	err = errors.New("没有可用instance")
+	return
+}
+
+func (sd *staticSd) MarkInstanceSuccess(options *service_discovery.MarkInstanceOptions) {}
+
+func (sd *staticSd) MarkInstanceFail(options *service_discovery.MarkInstanceOptions) {}
+
+func (sd *staticSd) ReleaseInstance(options *service_discovery.MarkInstanceOptions) {
+	sd.mu.Lock()
+	sd.released++
+	sd.mu.Unlock()
+}
+
+func (sd *staticSd) OnInstanceDown(callback func(instance *service_discovery.ServiceInstance)) {}
+
+// 把httptest服务作为节点
+func testInstance(t *testing.T, id string, server *httptest.Server) *service_discovery.ServiceInstance {
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	nport, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &service_discovery.ServiceInstance{ServiceName: "search.svc", ID: id, Ip: host, Port: uint64(nport)}
+}
+
+func TestHedge(t *testing.T) {
+	release := make(chan byte)
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		select {
+		case <-release:
+		case <-req.Context().Done(): // 落后的一路被中断
+		}
+		rw.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	sd := &staticSd{instances: []*service_discovery.ServiceInstance{testInstance(t, "slow", slow), testInstance(t, "fast", fast)}}
+	_, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{
+		Sd:           sd,
+		ServiceHedge: map[string]*HedgePolicy{"search.svc": {Delay: 50 * time.Millisecond}},
+	})
+	defer closeFunc()
+
+	wins := hedgeWins.Value()
+	startTime := time.Now()
+	resp, err := client.Get("http://search.svc/q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "fast" || time.Since(startTime) > 2*time.Second {
+		t.Fatal(string(body), time.Since(startTime))
+	}
+	if hedgeWins.Value() != wins+1 {
+		t.Fatal(hedgeWins.Value())
+	}
+	// 落后的一路被中断并释放
+	for i := 0; i < 100; i++ {
+		sd.mu.Lock()
+		released := sd.released
+		sd.mu.Unlock()
+		if released == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("落后的请求未被释放")
+}
+
+// 节点都尝试过时不再避开，与NacosServiceDiscovery一致
+type singleSd struct {
+	staticSd
+}
+
+func (sd *singleSd) SelectInstance(options *service_discovery.SelectInstanceOptions) (instance *service_discovery.ServiceInstance, err error) {
+	return sd.instances[0], nil
+}
+
+func TestHedgeSingleInstance(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		rw.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	sd := &singleSd{}
+	sd.instances = []*service_discovery.ServiceInstance{testInstance(t, "1", backend)}
+	_, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{
+		Sd:           sd,
+		ServiceHedge: map[string]*HedgePolicy{"search.svc": {Delay: 20 * time.Millisecond}},
+	})
+	defer closeFunc()
+
+	// 没有其他节点，不对冲，选出的节点被释放
+	hedged := hedgedRequests.Value()
+	resp, err := client.Get("http://search.svc/q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" || hedgedRequests.Value() != hedged {
+		t.Fatal(string(body), hedgedRequests.Value()-hedged)
+	}
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	if sd.released != 1 {
+		t.Fatal(sd.released)
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	window := newLatencyWindow()
+	if _, ok := window.percentile(95); ok {
+		t.Fatal("样本不足")
+	}
+	for i := 1; i <= 200; i++ {
+		window.add(time.Duration(i) * time.Millisecond)
+	}
+	// 只保留最近的样本：73ms~200ms
+	if latency, ok := window.percentile(95); !ok || latency < 190*time.Millisecond || latency > 200*time.Millisecond {
+		t.Fatal(latency)
+	}
+}
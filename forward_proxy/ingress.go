@@ -0,0 +1,110 @@
+package forward_proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 入口路由规则
+type IngressRoute struct {
+	Host        string // 匹配的Host（不含端口），为空匹配任意Host
+	PathPrefix  string // 匹配的路径前缀（按/分段），为空匹配任意路径
+	ServiceName string // 转发到的nacos服务名
+	StripPrefix bool   // 转发前去掉路径前缀
+}
+
+// 入口（反向代理）配置
+type IngressConfig struct {
+	ListenAddr string          // 监听地址
+	Routes     []*IngressRoute // 路由规则，指定Host优先于任意Host，其次最长路径前缀优先
+}
+
+// 保存请求到达入口时的Host
+type ingressHostKey struct{}
+
+// 请求到达时的Host，入口请求转发前会被改写为服务名
+func originalHost(req *http.Request) string {
+	if host, ok := req.Context().Value(ingressHostKey{}).(string); ok {
+		return host
+	}
+	return req.Host
+}
+
+// 路径是否匹配前缀，按/分段，/api不匹配/apix
+func matchPathPrefix(path string, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// 是否比另一规则更具体：指定Host优先，其次路径前缀更长，相同时先配置的优先
+func (route *IngressRoute) moreSpecific(other *IngressRoute) bool {
+	if (route.Host != "") != (other.Host != "") {
+		return route.Host != ""
+	}
+	return len(route.PathPrefix) > len(other.PathPrefix)
+}
+
+// 为请求选择路由规则，未匹配返回nil
+func (forwardProxy *ForwardProxy) matchIngressRoute(req *http.Request) (route *IngressRoute) {
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+	for _, candidate := range forwardProxy.config.Ingress.Routes {
+		if candidate.Host != "" && !strings.EqualFold(candidate.Host, host) {
+			continue
+		}
+		if candidate.PathPrefix != "" && !matchPathPrefix(req.URL.Path, candidate.PathPrefix) {
+			continue
+		}
+		if route == nil || candidate.moreSpecific(route) {
+			route = candidate
+		}
+	}
+	return
+}
+
+// 入口请求：按路由规则改写为发往服务的请求，再与正向代理一样转发
+func (forwardProxy *ForwardProxy) handleIngressRequest(rw http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodConnect {
+		writeProxyError(rw, PROXY_ERROR_DENIED, "", fmt.Errorf("CONNECT is not allowed on ingress"))
+		return
+	}
+	route := forwardProxy.matchIngressRoute(req)
+	if route == nil {
+		writeProxyError(rw, PROXY_ERROR_NO_ROUTE, "", fmt.Errorf("no route for %s%s", req.Host, req.URL.Path))
+		return
+	}
+
+	// 服务名作为Host，重试、超时等按服务名生效，原Host通过X-Forwarded-Host告知服务端
+	if !forwardProxy.config.DisableForwardedHeaders {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), ingressHostKey{}, req.Host))
+	req.Host = route.ServiceName
+	req.URL.Scheme = "http"
+	req.URL.Host = route.ServiceName
+	if route.StripPrefix && route.PathPrefix != "" {
+		req.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, route.PathPrefix), "/")
+		req.URL.RawPath = ""
+	}
+
+	if isUpgradeRequest(req) {
+		forwardProxy.handleUpgradeRequest(rw, req)
+	} else {
+		forwardProxy.handleHttpRequest(rw, req)
+	}
+}
+
+// 启动入口监听，Shutdown后返回nil
+func (forwardProxy *ForwardProxy) RunIngress() (err error) {
+	if err = forwardProxy.ingressServer.ListenAndServe(); err == http.ErrServerClosed {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package forward_proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+func TestMatchIngressRoute(t *testing.T) {
+	forwardProxy := &ForwardProxy{config: &ForwardProxyConfig{Ingress: &IngressConfig{Routes: []*IngressRoute{
+		{ServiceName: "default.svc"},
+		{PathPrefix: "/api", ServiceName: "api.svc"},
+		{PathPrefix: "/api/v2/", ServiceName: "api-v2.svc"},
+		{Host: "shop.example.com", ServiceName: "shop.svc"},
+		{Host: "shop.example.com", PathPrefix: "/static", ServiceName: "static.svc"},
+	}}}}
+	cases := []struct {
+		url     string
+		service string
+	}{
+		{"http://www.example.com/", "default.svc"},
+		{"http://www.example.com/api", "api.svc"},
+		{"http://www.example.com/api/users", "api.svc"},
+		{"http://www.example.com/apix", "default.svc"}, // 按/分段匹配
+		{"http://www.example.com/api/v2/users", "api-v2.svc"},
+		{"http://shop.example.com:8080/api", "shop.svc"}, // 指定Host优先
+		{"http://SHOP.example.com/static/a.css", "static.svc"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.url, nil)
+		if route := forwardProxy.matchIngressRoute(req); route == nil || route.ServiceName != c.service {
+			t.Fatal(c.url, route)
+		}
+	}
+}
+
+func TestIngress(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(rw, "%s %s %s", req.Host, req.URL.Path, req.Header.Get("X-Forwarded-Host"))
+	}))
+	defer backend.Close()
+
+	sd := &staticSd{instances: []*service_discovery.ServiceInstance{testInstance(t, "1", backend)}}
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{
+		Sd:         sd,
+		RetryTimes: 1,
+		Ingress: &IngressConfig{Routes: []*IngressRoute{
+			{Host: "www.example.com", PathPrefix: "/search", ServiceName: "search.svc", StripPrefix: true},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingressServer := httptest.NewServer(http.HandlerFunc(forwardProxy.handleIngressRequest))
+	defer ingressServer.Close()
+
+	// 普通请求按Host及路径前缀转发到服务
+	req, _ := http.NewRequest(http.MethodGet, ingressServer.URL+"/search/q", nil)
+	req.Host = "www.example.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "search.svc /q www.example.com" {
+		t.Fatal(resp.StatusCode, string(body))
+	}
+
+	// 未匹配的请求
+	req, _ = http.NewRequest(http.MethodGet, ingressServer.URL+"/other", nil)
+	req.Host = "www.example.com"
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get("X-Proxy-Error") != PROXY_ERROR_NO_ROUTE {
+		t.Fatal(resp.StatusCode)
+	}
+}
@@ -0,0 +1,92 @@
+package forward_proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 转发结果分类
+const (
+	OUTCOME_SUCCESS    = iota // 成功
+	OUTCOME_DIAL_FAIL         // 建连失败
+	OUTCOME_TIMEOUT           // 超时
+	OUTCOME_BAD_STATUS        // 服务端返回了配置为失败的状态码
+	OUTCOME_RESET             // 传输中途连接被重置
+	OUTCOME_CANCELED          // 客户端离开，不计成败
+)
+
+// 是否超时
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// 是否建连失败
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// 是否连接被重置
+func isReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// 对转发错误分类
+func classifyError(err error) (outcome int) {
+	switch {
+	case err == nil:
+		outcome = OUTCOME_SUCCESS
+	case errors.Is(err, context.Canceled):
+		outcome = OUTCOME_CANCELED
+	case isTimeout(err):
+		outcome = OUTCOME_TIMEOUT
+	case isDialError(err):
+		outcome = OUTCOME_DIAL_FAIL
+	default: // 其他传输错误均视为中途被重置
+		outcome = OUTCOME_RESET
+	}
+	return
+}
+
+// 对HTTP转发结果分类
+func (forwardProxy *ForwardProxy) classifyHttpOutcome(resp *http.Response, err error) (outcome int) {
+	if outcome = classifyError(err); outcome == OUTCOME_SUCCESS && resp != nil && forwardProxy.config.FailStatusCodes[resp.StatusCode] {
+		outcome = OUTCOME_BAD_STATUS
+	}
+	return
+}
+
+// 向服务发现上报节点的转发结果，驱动熔断器
+func (forwardProxy *ForwardProxy) reportOutcome(ins *service_discovery.ServiceInstance, outcome int, latency time.Duration) {
+	if ins == nil { // 走的DNS，无需上报
+		return
+	}
+	options := &service_discovery.MarkInstanceOptions{
+		ServiceName: ins.ServiceName,
+		ID:          ins.ID,
+		Latency:     latency,
+	}
+	switch outcome {
+	case OUTCOME_SUCCESS:
+		forwardProxy.config.Sd.MarkInstanceSuccess(options)
+	case OUTCOME_CANCELED:
+		forwardProxy.config.Sd.ReleaseInstance(options)
+	default:
+		forwardProxy.config.Sd.MarkInstanceFail(options)
+	}
+}
@@ -0,0 +1,48 @@
+package forward_proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	// 建连被拒绝
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+	_, err := net.Dial("tcp", addr)
+	if classifyError(err) != OUTCOME_DIAL_FAIL {
+		t.Fatal(err)
+	}
+
+	// 超时
+	ctx, cancelFunc := context.WithTimeout(context.TODO(), time.Millisecond)
+	defer cancelFunc()
+	<-ctx.Done()
+	if classifyError(ctx.Err()) != OUTCOME_TIMEOUT {
+		t.Fatal()
+	}
+
+	// 客户端离开
+	if classifyError(context.Canceled) != OUTCOME_CANCELED {
+		t.Fatal()
+	}
+
+	// 其他传输错误
+	if classifyError(errors.New("broken")) != OUTCOME_RESET {
+		t.Fatal()
+	}
+
+	// 失败状态码
+	forwardProxy := &ForwardProxy{config: &ForwardProxyConfig{FailStatusCodes: map[int]bool{503: true}}}
+	if forwardProxy.classifyHttpOutcome(&http.Response{StatusCode: 503}, nil) != OUTCOME_BAD_STATUS {
+		t.Fatal()
+	}
+	if forwardProxy.classifyHttpOutcome(&http.Response{StatusCode: 500}, nil) != OUTCOME_SUCCESS {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,207 @@
+package forward_proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// 连接池超过该时间未使用则退役，IdleConnTimeout不限制时使用
+const POOL_UNUSED_TIMEOUT = 10 * time.Minute
+
+// 上游连接池配置
+type PoolConfig struct {
+	MaxIdleConnsPerHost int           // 每个节点最多保留的空闲连接
+	IdleConnTimeout     time.Duration // 空闲连接超时
+	MaxConnLifetime     time.Duration // 连接最长存活时间，<=0表示不限制
+}
+
+// 1个节点的连接池
+type hostTransport struct {
+	transport  *http.Transport
+	h2c        *http2.Transport // 明文HTTP/2，连接自行建立以便建连遵循请求的context及超时
+	createTime time.Time        // 创建时间，超过MaxConnLifetime后整体轮换
+	retireTime time.Time        // 退役时间
+	lastUsed   time.Time        // 最近使用时间，长期未用则退役（如DNS回源的外部域名）
+
+	h2cMu       sync.Mutex
+	h2cConn     *http2.ClientConn // 复用的h2c连接
+	h2cLastUsed time.Time
+}
+
+// 上游连接池，按服务发现选中的节点(ip:port)隔离
+type upstreamPool struct {
+	config *PoolConfig
+	dialer *net.Dialer
+
+	mu         sync.Mutex
+	transports map[string]*hostTransport // ip:port -> 连接池
+	retired    []*hostTransport          // 已退役的连接池，等待其上的请求结束后关闭空闲连接
+}
+
+func newUpstreamPool(config *PoolConfig, dialer *net.Dialer) (pool *upstreamPool) {
+	pool = &upstreamPool{
+		config:     config,
+		dialer:     dialer,
+		transports: make(map[string]*hostTransport),
+	}
+	go pool.cleanForever()
+	return
+}
+
+func (pool *upstreamPool) newHostTransport() *hostTransport {
+	return &hostTransport{
+		transport: &http.Transport{
+			DialContext:         pool.dialContext,
+			DialTLSContext:      pool.dialTLSContext,
+			MaxIdleConnsPerHost: pool.config.MaxIdleConnsPerHost,
+			IdleConnTimeout:     pool.config.IdleConnTimeout,
+		},
+		h2c:        &http2.Transport{AllowHTTP: true},
+		createTime: time.Now(),
+	}
+}
+
+// 取可用的h2c连接，没有则按服务的超时策略新建
+func (pool *upstreamPool) h2cClientConn(ht *hostTransport, req *http.Request) (cc *http2.ClientConn, err error) {
+	ht.h2cMu.Lock()
+	cc = ht.h2cConn
+	ht.h2cLastUsed = time.Now()
+	ht.h2cMu.Unlock()
+	if cc != nil && cc.CanTakeNewRequest() {
+		return
+	}
+
+	var conn net.Conn
+	if conn, err = pool.dialContext(req.Context(), "tcp", req.URL.Host); err != nil {
+		return
+	}
+	if cc, err = ht.h2c.NewClientConn(conn); err != nil {
+		conn.Close()
+		return
+	}
+	ht.h2cMu.Lock()
+	old := ht.h2cConn
+	ht.h2cConn = cc
+	ht.h2cMu.Unlock()
+	if old != nil { // 流数已满或收到GOAWAY，等其上的请求结束后关闭
+		go old.Shutdown(context.Background())
+	}
+	return
+}
+
+// 关闭超过idleTimeout未使用的h2c连接，0表示立即关闭，其上的请求结束后才断开
+func (ht *hostTransport) closeIdleH2c(idleTimeout time.Duration) {
+	ht.h2cMu.Lock()
+	cc := ht.h2cConn
+	if cc == nil || idleTimeout > 0 && time.Since(ht.h2cLastUsed) < idleTimeout {
+		ht.h2cMu.Unlock()
+		return
+	}
+	ht.h2cConn = nil
+	ht.h2cMu.Unlock()
+	go cc.Shutdown(context.Background())
+}
+
+// 退役连接池（需持有pool.mu）
+func (pool *upstreamPool) retire(addr string, ht *hostTransport) {
+	delete(pool.transports, addr)
+	ht.retireTime = time.Now()
+	ht.transport.CloseIdleConnections()
+	ht.closeIdleH2c(0)
+	pool.retired = append(pool.retired, ht)
+}
+
+// 取节点的连接池，超过最长存活时间则轮换
+func (pool *upstreamPool) get(addr string) (ht *hostTransport) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	ht, exist := pool.transports[addr]
+	if exist && pool.config.MaxConnLifetime > 0 && time.Since(ht.createTime) >= pool.config.MaxConnLifetime {
+		pool.retire(addr, ht)
+		exist = false
+	}
+	if !exist {
+		ht = pool.newHostTransport()
+		pool.transports[addr] = ht
+	}
+	ht.lastUsed = time.Now()
+	return
+}
+
+// 节点下线或熔断，主动淘汰其连接
+func (pool *upstreamPool) evict(addr string) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if ht, exist := pool.transports[addr]; exist {
+		pool.retire(addr, ht)
+	}
+}
+
+// 定期清理：长期未使用的连接池退役，退役连接池上的空闲连接关闭，超过空闲超时后丢弃（剩余连接由空闲超时自行关闭）
+func (pool *upstreamPool) cleanForever() {
+	for {
+		time.Sleep(5 * time.Second)
+		pool.clean()
+	}
+}
+
+// 执行1次清理
+func (pool *upstreamPool) clean() {
+	unusedTimeout := pool.config.IdleConnTimeout
+	if unusedTimeout <= 0 {
+		unusedTimeout = POOL_UNUSED_TIMEOUT
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	// 地址包括DNS回源的外部域名，不清理会只增不减
+	for addr, ht := range pool.transports {
+		if time.Since(ht.lastUsed) >= unusedTimeout {
+			pool.retire(addr, ht)
+		}
+	}
+
+	retired := pool.retired[:0]
+	for _, ht := range pool.retired {
+		ht.transport.CloseIdleConnections()
+		if time.Since(ht.retireTime) < pool.config.IdleConnTimeout {
+			retired = append(retired, ht)
+		}
+	}
+	pool.retired = retired
+
+	// h2c连接不受http.Transport的空闲超时管理
+	if pool.config.IdleConnTimeout > 0 {
+		for _, ht := range pool.transports {
+			ht.closeIdleH2c(pool.config.IdleConnTimeout)
+		}
+	}
+}
+
+// 实现http.RoundTripper，按目标地址选择连接池，gRPC及配置了h2c的服务以明文HTTP/2转发
+func (pool *upstreamPool) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	if req.URL.Host == "" {
+		err = errors.New("目标地址为空")
+		return
+	}
+	ht := pool.get(req.URL.Host)
+	if upstream, ok := req.Context().Value(upstreamContextKey{}).(*upstreamContext); ok && upstream.h2c {
+		var cc *http2.ClientConn
+		if cc, err = pool.h2cClientConn(ht, req); err != nil {
+			return
+		}
+		resp, err = cc.RoundTrip(req)
+		return
+	}
+	resp, err = ht.transport.RoundTrip(req)
+	return
+}
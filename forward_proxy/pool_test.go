@@ -0,0 +1,81 @@
+package forward_proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpstreamPool(t *testing.T) {
+	var newConns int64
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&newConns, 1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "http://")
+
+	forwardProxy, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{
+		Pool: &PoolConfig{MaxIdleConnsPerHost: 4, IdleConnTimeout: time.Minute, MaxConnLifetime: 200 * time.Millisecond},
+	})
+	defer closeFunc()
+
+	get := func() {
+		resp, err := client.Get(backend.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	// 连接复用
+	for i := 0; i < 3; i++ {
+		get()
+	}
+	if atomic.LoadInt64(&newConns) != 1 {
+		t.Fatal(newConns)
+	}
+
+	// 节点淘汰后重新建连
+	forwardProxy.pool.evict(addr)
+	get()
+	if atomic.LoadInt64(&newConns) != 2 {
+		t.Fatal(newConns)
+	}
+
+	// 超过最长存活时间后轮换
+	time.Sleep(300 * time.Millisecond)
+	get()
+	if atomic.LoadInt64(&newConns) != 3 {
+		t.Fatal(newConns)
+	}
+}
+
+func TestUpstreamPoolClean(t *testing.T) {
+	pool := newUpstreamPool(&PoolConfig{IdleConnTimeout: time.Minute}, &net.Dialer{})
+	pool.get("10.0.0.1:80")
+	pool.get("www.example.com:80")
+
+	// 长期未使用的连接池退役，最近使用的保留
+	pool.mu.Lock()
+	pool.transports["www.example.com:80"].lastUsed = time.Now().Add(-2 * time.Minute)
+	pool.mu.Unlock()
+	pool.clean()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if _, exist := pool.transports["www.example.com:80"]; exist || len(pool.transports) != 1 || len(pool.retired) != 1 {
+		t.Fatal(pool.transports, pool.retired)
+	}
+}
@@ -0,0 +1,48 @@
+package forward_proxy
+
+import (
+	"context"
+	"net"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 本地端口到服务的静态映射，供无法使用代理协议的应用（如MySQL、Redis客户端）
+type PortMapping struct {
+	ListenAddr   string // 本地监听地址，如127.0.0.1:13306
+	ServiceName  string // nacos服务名
+	FallbackAddr string // 服务发现失败时连接的地址，为空则直接断开
+}
+
+// 启动1个端口映射，Shutdown后返回nil
+func (forwardProxy *ForwardProxy) RunPortMapping(mapping *PortMapping) (err error) {
+	var listener net.Listener
+	if listener, err = forwardProxy.listen("tcp", mapping.ListenAddr); err != nil {
+		return
+	}
+	return forwardProxy.serveTcp(listener, func(clientConn net.Conn) {
+		forwardProxy.handleMappedConn(mapping, clientConn)
+	})
+}
+
+// 转发映射端口上的1个连接
+func (forwardProxy *ForwardProxy) handleMappedConn(mapping *PortMapping, clientConn net.Conn) {
+	defer clientConn.Close()
+
+	clientCtx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+	options := &service_discovery.SelectInstanceOptions{ServiceName: mapping.ServiceName}
+	if host, _, err := net.SplitHostPort(clientConn.RemoteAddr().String()); err == nil {
+		options.ClientIp = host
+	}
+	timeouts := forwardProxy.timeoutPolicy(mapping.ServiceName)
+	serverConn, serverIns, _, dialLatency, err := forwardProxy.dialUpstream(clientCtx, options, mapping.FallbackAddr, timeouts)
+	if err != nil {
+		return
+	}
+	defer serverConn.Close()
+
+	// 长连接可能持续数小时，建连成功即上报节点结果，以便熔断器及时恢复
+	forwardProxy.reportOutcome(serverIns, OUTCOME_SUCCESS, dialLatency)
+	forwardProxy.transferTunnel(mapping.ServiceName, clientConn, serverConn, nil, dialLatency, timeouts.TunnelIdle)
+}
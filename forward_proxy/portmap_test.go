@@ -0,0 +1,94 @@
+package forward_proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 记录上报结果
+type markSd struct {
+	staticSd
+	markMu  sync.Mutex
+	success int
+	fail    int
+}
+
+func (sd *markSd) MarkInstanceSuccess(options *service_discovery.MarkInstanceOptions) {
+	sd.markMu.Lock()
+	sd.success++
+	sd.markMu.Unlock()
+}
+
+func (sd *markSd) MarkInstanceFail(options *service_discovery.MarkInstanceOptions) {
+	sd.markMu.Lock()
+	sd.fail++
+	sd.markMu.Unlock()
+}
+
+func TestPortMapping(t *testing.T) {
+	// 逐行回显的服务端
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				conn.Write([]byte(line))
+			}()
+		}
+	}()
+	addr := backend.Addr().(*net.TCPAddr)
+
+	sd := &markSd{staticSd: staticSd{instances: []*service_discovery.ServiceInstance{
+		{ServiceName: "redis.svc", ID: "1", Ip: addr.IP.String(), Port: uint64(addr.Port)},
+	}}}
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{Sd: sd, RetryTimes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := &PortMapping{ListenAddr: "127.0.0.1:0", ServiceName: "redis.svc"}
+	listener, err := forwardProxy.listen("tcp", mapping.ListenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go forwardProxy.serveTcp(listener, func(conn net.Conn) {
+		forwardProxy.handleMappedConn(mapping, conn)
+	})
+	defer func() {
+		ctx, cancelFunc := context.WithTimeout(context.TODO(), time.Second)
+		defer cancelFunc()
+		forwardProxy.Shutdown(ctx)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	fmt.Fprint(conn, "PING\n")
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "PING\n" {
+		t.Fatal(line, err)
+	}
+	// 建连成功即上报
+	sd.markMu.Lock()
+	defer sd.markMu.Unlock()
+	if sd.success != 1 || sd.fail != 0 {
+		t.Fatal(sd.success, sd.fail)
+	}
+}
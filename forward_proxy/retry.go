@@ -0,0 +1,94 @@
+package forward_proxy
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// 重试条件
+const (
+	RETRY_ON_CONNECT_FAILURE = "connect-failure" // 建连失败，请求未发出，与方法无关
+	RETRY_ON_RESET           = "reset"           // 连接被重置
+	RETRY_ON_TIMEOUT         = "timeout"         // 超时
+)
+
+// 重试策略
+type RetryPolicy struct {
+	MaxAttempts   int             // 最多尝试次数，含首次
+	Methods       map[string]bool // 可重试的方法（幂等），建连失败不受此限制
+	StatusCodes   map[int]bool    // 服务端返回这些状态码时重试
+	RetryOn       map[string]bool // 可重试的错误条件
+	BaseBackoff   time.Duration   // 退避基数，按2的指数增长，全抖动
+	MaxBackoff    time.Duration   // 退避上限
+	PerTryTimeout time.Duration   // 单次尝试超时，0不限制
+}
+
+// 默认只重试幂等方法
+func DefaultRetryMethods() map[string]bool {
+	return map[string]bool{
+		http.MethodGet:     true,
+		http.MethodHead:    true,
+		http.MethodOptions: true,
+		http.MethodTrace:   true,
+		http.MethodPut:     true,
+		http.MethodDelete:  true,
+	}
+}
+
+// 服务的重试策略
+func (forwardProxy *ForwardProxy) retryPolicy(serviceName string) *RetryPolicy {
+	if policy, exist := forwardProxy.config.ServiceRetry[serviceName]; exist {
+		return policy
+	}
+	return forwardProxy.config.Retry
+}
+
+// 错误是否可重试
+func (policy *RetryPolicy) retryableError(method string, outcome int) bool {
+	switch outcome {
+	case OUTCOME_DIAL_FAIL:
+		return policy.RetryOn[RETRY_ON_CONNECT_FAILURE]
+	case OUTCOME_RESET:
+		return policy.Methods[method] && policy.RetryOn[RETRY_ON_RESET]
+	case OUTCOME_TIMEOUT:
+		return policy.Methods[method] && policy.RetryOn[RETRY_ON_TIMEOUT]
+	}
+	return false
+}
+
+// 应答状态码是否可重试
+func (policy *RetryPolicy) retryableStatus(method string, statusCode int) bool {
+	return policy.Methods[method] && policy.StatusCodes[statusCode]
+}
+
+// 第attempt次重试前的退避时间（attempt从1开始）
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	if policy.BaseBackoff <= 0 {
+		return 0
+	}
+	ceiling := policy.BaseBackoff << uint(attempt-1)
+	if ceiling <= 0 || (policy.MaxBackoff > 0 && ceiling > policy.MaxBackoff) { // 溢出或超过上限
+		ceiling = policy.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// 退避等待，客户端离开则返回false
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	if duration <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,77 @@
+package forward_proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryStatus(t *testing.T) {
+	var counter int32
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// 奇数次返回503
+		if atomic.AddInt32(&counter, 1)%2 == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+
+	policy := &RetryPolicy{
+		MaxAttempts: 3,
+		Methods:     DefaultRetryMethods(),
+		StatusCodes: map[int]bool{http.StatusServiceUnavailable: true},
+	}
+	_, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{Retry: policy, SpoolThreshold: 1024})
+	defer closeFunc()
+
+	// 幂等方法重试成功
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Proxy-Attempts") != "2" {
+		t.Fatal(resp.StatusCode, resp.Header)
+	}
+
+	// 非幂等方法不重试
+	resp, err = client.Post(backend.URL, "text/plain", strings.NewReader("charge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("X-Proxy-Attempts") != "1" {
+		t.Fatal(resp.StatusCode, resp.Header)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := &RetryPolicy{BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for attempt := 1; attempt < 100; attempt++ {
+		if backoff := policy.backoff(attempt); backoff < 0 || backoff > policy.MaxBackoff {
+			t.Fatal(attempt, backoff)
+		}
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	policy := &RetryPolicy{
+		Methods: DefaultRetryMethods(),
+		RetryOn: map[string]bool{RETRY_ON_CONNECT_FAILURE: true, RETRY_ON_RESET: true},
+	}
+	// 建连失败时请求未发出，任何方法都可重试
+	if !policy.retryableError(http.MethodPost, OUTCOME_DIAL_FAIL) {
+		t.Fatal()
+	}
+	// 请求可能已被处理，只重试幂等方法
+	if policy.retryableError(http.MethodPost, OUTCOME_RESET) || !policy.retryableError(http.MethodGet, OUTCOME_RESET) {
+		t.Fatal()
+	}
+	// 未开启超时重试
+	if policy.retryableError(http.MethodGet, OUTCOME_TIMEOUT) {
+		t.Fatal()
+	}
+}
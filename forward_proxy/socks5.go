@@ -0,0 +1,244 @@
+package forward_proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// SOCKS5协议常量（RFC 1928/1929）
+const (
+	SOCKS5_VERSION           = 0x05
+	SOCKS5_AUTH_VERSION      = 0x01
+	SOCKS5_AUTH_NONE         = 0x00
+	SOCKS5_AUTH_PASSWORD     = 0x02
+	SOCKS5_AUTH_UNACCEPTED   = 0xff
+	SOCKS5_CMD_CONNECT       = 0x01
+	SOCKS5_ATYP_IPV4         = 0x01
+	SOCKS5_ATYP_DOMAIN       = 0x03
+	SOCKS5_ATYP_IPV6         = 0x04
+	SOCKS5_REP_SUCCESS       = 0x00
+	SOCKS5_REP_FAILURE       = 0x01
+	SOCKS5_REP_NOT_ALLOWED   = 0x02
+	SOCKS5_REP_UNREACHABLE   = 0x04
+	SOCKS5_REP_REFUSED       = 0x05
+	SOCKS5_REP_TTL_EXPIRED   = 0x06
+	SOCKS5_REP_CMD_UNSUPP    = 0x07
+	SOCKS5_HANDSHAKE_TIMEOUT = 10 * time.Second
+)
+
+// SOCKS5配置
+type Socks5Config struct {
+	ListenAddr string // 监听地址
+	Username   string // 为空则无需认证
+	Password   string
+
+	KnownServices map[string]bool // 已知的服务名，目标host:port在其中时才以host:port做服务发现
+}
+
+// 启动SOCKS5监听，Shutdown后返回nil
+func (forwardProxy *ForwardProxy) RunSocks5() (err error) {
+	var listener net.Listener
+	if listener, err = forwardProxy.listen("tcp", forwardProxy.config.Socks5.ListenAddr); err != nil {
+		return
+	}
+	return forwardProxy.serveTcp(listener, forwardProxy.handleSocks5Conn)
+}
+
+// 处理1个SOCKS5连接
+func (forwardProxy *ForwardProxy) handleSocks5Conn(clientConn net.Conn) {
+	defer clientConn.Close()
+
+	// 握手及请求
+	clientConn.SetDeadline(time.Now().Add(SOCKS5_HANDSHAKE_TIMEOUT))
+	if err := forwardProxy.socks5Auth(clientConn); err != nil {
+		return
+	}
+	target, err := readSocks5Request(clientConn)
+	if err != nil {
+		if err == errSocks5CmdUnsupported {
+			writeSocks5Reply(clientConn, SOCKS5_REP_CMD_UNSUPP, nil)
+		}
+		return
+	}
+	if !forwardProxy.connectAllowed(target) {
+		writeSocks5Reply(clientConn, SOCKS5_REP_NOT_ALLOWED, nil)
+		return
+	}
+
+	// 服务发现并建连：以不含端口的域名作为服务名，没有节点则走DNS；
+	// 已知以host:port命名的服务（与CONNECT一致）直接以host:port发现，普通域名只查1次
+	clientCtx, cancelFunc := context.WithCancel(context.TODO())
+	defer cancelFunc()
+	serviceName := target
+	if !forwardProxy.config.Socks5.KnownServices[target] {
+		serviceName, _, _ = net.SplitHostPort(target)
+	}
+	options := &service_discovery.SelectInstanceOptions{ServiceName: serviceName}
+	if clientIp, _, err := net.SplitHostPort(clientConn.RemoteAddr().String()); err == nil {
+		options.ClientIp = clientIp
+	}
+	timeouts := forwardProxy.timeoutPolicy(serviceName)
+	serverConn, serverIns, _, dialLatency, err := forwardProxy.dialUpstream(clientCtx, options, target, timeouts)
+	if err != nil {
+		writeSocks5Reply(clientConn, socks5ReplyCode(err), nil)
+		return
+	}
+	defer serverConn.Close()
+
+	if err = writeSocks5Reply(clientConn, SOCKS5_REP_SUCCESS, serverConn.LocalAddr()); err != nil {
+		forwardProxy.reportOutcome(serverIns, OUTCOME_CANCELED, dialLatency)
+		return
+	}
+	clientConn.SetDeadline(time.Time{})
+
+	forwardProxy.transferTunnel(serviceName, clientConn, serverConn, serverIns, dialLatency, timeouts.TunnelIdle)
+}
+
+// 协商认证方式，配置了用户名则要求用户名/密码认证
+func (forwardProxy *ForwardProxy) socks5Auth(clientConn net.Conn) (err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(clientConn, header); err != nil {
+		return
+	}
+	if header[0] != SOCKS5_VERSION {
+		return errors.New("不支持的SOCKS版本")
+	}
+	methods := make([]byte, header[1])
+	if _, err = io.ReadFull(clientConn, methods); err != nil {
+		return
+	}
+
+	expected := byte(SOCKS5_AUTH_NONE)
+	if forwardProxy.config.Socks5.Username != "" {
+		expected = SOCKS5_AUTH_PASSWORD
+	}
+	offered := false
+	for _, method := range methods {
+		if method == expected {
+			offered = true
+		}
+	}
+	if !offered {
+		clientConn.Write([]byte{SOCKS5_VERSION, SOCKS5_AUTH_UNACCEPTED})
+		return errors.New("客户端不支持要求的认证方式")
+	}
+	if _, err = clientConn.Write([]byte{SOCKS5_VERSION, expected}); err != nil || expected == SOCKS5_AUTH_NONE {
+		return
+	}
+
+	// 用户名/密码认证
+	var username, password []byte
+	version := make([]byte, 1)
+	if _, err = io.ReadFull(clientConn, version); err != nil {
+		return
+	}
+	if username, err = readSocks5String(clientConn); err != nil {
+		return
+	}
+	if password, err = readSocks5String(clientConn); err != nil {
+		return
+	}
+	userOk := subtle.ConstantTimeCompare(username, []byte(forwardProxy.config.Socks5.Username)) == 1
+	passOk := subtle.ConstantTimeCompare(password, []byte(forwardProxy.config.Socks5.Password)) == 1
+	if version[0] != SOCKS5_AUTH_VERSION || !userOk || !passOk {
+		clientConn.Write([]byte{SOCKS5_AUTH_VERSION, 0x01})
+		return errors.New("认证失败")
+	}
+	_, err = clientConn.Write([]byte{SOCKS5_AUTH_VERSION, 0x00})
+	return
+}
+
+// 读取1字节长度+内容
+func readSocks5String(reader io.Reader) (value []byte, err error) {
+	length := make([]byte, 1)
+	if _, err = io.ReadFull(reader, length); err != nil {
+		return
+	}
+	value = make([]byte, length[0])
+	_, err = io.ReadFull(reader, value)
+	return
+}
+
+var errSocks5CmdUnsupported = errors.New("只支持CONNECT")
+
+// 读取请求，返回目标host:port
+func readSocks5Request(reader io.Reader) (target string, err error) {
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return
+	}
+	if header[0] != SOCKS5_VERSION {
+		err = errors.New("不支持的SOCKS版本")
+		return
+	}
+
+	var host string
+	switch header[3] {
+	case SOCKS5_ATYP_IPV4, SOCKS5_ATYP_IPV6:
+		ip := make([]byte, net.IPv4len)
+		if header[3] == SOCKS5_ATYP_IPV6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err = io.ReadFull(reader, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case SOCKS5_ATYP_DOMAIN:
+		var domain []byte
+		if domain, err = readSocks5String(reader); err != nil {
+			return
+		}
+		host = string(domain)
+	default:
+		err = errors.New("不支持的地址类型")
+		return
+	}
+	port := make([]byte, 2)
+	if _, err = io.ReadFull(reader, port); err != nil {
+		return
+	}
+	if header[1] != SOCKS5_CMD_CONNECT {
+		err = errSocks5CmdUnsupported
+		return
+	}
+	target = net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	return
+}
+
+// 回复请求结果
+func writeSocks5Reply(writer io.Writer, rep byte, bindAddr net.Addr) (err error) {
+	reply := []byte{SOCKS5_VERSION, rep, 0x00}
+	tcpAddr, _ := bindAddr.(*net.TCPAddr)
+	if tcpAddr == nil {
+		tcpAddr = &net.TCPAddr{IP: net.IPv4zero}
+	}
+	if ip := tcpAddr.IP.To4(); ip != nil {
+		reply = append(append(reply, SOCKS5_ATYP_IPV4), ip...)
+	} else {
+		reply = append(append(reply, SOCKS5_ATYP_IPV6), tcpAddr.IP.To16()...)
+	}
+	reply = append(reply, byte(tcpAddr.Port>>8), byte(tcpAddr.Port))
+	_, err = writer.Write(reply)
+	return
+}
+
+// 建连错误对应的回复码
+func socks5ReplyCode(err error) byte {
+	switch classifyProxyError(err) {
+	case PROXY_ERROR_NO_INSTANCE:
+		return SOCKS5_REP_UNREACHABLE
+	case PROXY_ERROR_UPSTREAM_TIMEOUT:
+		return SOCKS5_REP_TTL_EXPIRED
+	case PROXY_ERROR_CONNECT_FAILED:
+		return SOCKS5_REP_REFUSED
+	}
+	return SOCKS5_REP_FAILURE
+}
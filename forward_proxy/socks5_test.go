@@ -0,0 +1,154 @@
+package forward_proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+	"golang.org/x/net/proxy"
+)
+
+func TestSocks5(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{
+		Sd:         &dnsOnlySd{},
+		RetryTimes: 1,
+		Socks5:     &Socks5Config{ListenAddr: "127.0.0.1:0", Username: "user", Password: "pass"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := forwardProxy.listen("tcp", forwardProxy.config.Socks5.ListenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go forwardProxy.serveTcp(listener, forwardProxy.handleSocks5Conn)
+	defer func() {
+		ctx, cancelFunc := context.WithTimeout(context.TODO(), time.Second)
+		defer cancelFunc()
+		forwardProxy.Shutdown(ctx)
+	}()
+
+	// 认证通过，经隧道访问服务端
+	dialer, _ := proxy.SOCKS5("tcp", listener.Addr().String(), &proxy.Auth{User: "user", Password: "pass"}, proxy.Direct)
+	client := &http.Client{Transport: &http.Transport{Dial: dialer.Dial}}
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatal(string(body))
+	}
+
+	// 密码错误
+	dialer, _ = proxy.SOCKS5("tcp", listener.Addr().String(), &proxy.Auth{User: "user", Password: "wrong"}, proxy.Direct)
+	if conn, err := dialer.Dial("tcp", backend.Listener.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("认证应失败")
+	}
+
+	// 目标端口不通
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := closed.Addr().String()
+	closed.Close()
+	dialer, _ = proxy.SOCKS5("tcp", listener.Addr().String(), &proxy.Auth{User: "user", Password: "pass"}, proxy.Direct)
+	if conn, err := dialer.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Fatal("建连应失败")
+	}
+}
+
+// 只认指定服务名的服务发现，记录查询过的服务名
+type namedSd struct {
+	staticSd
+	serviceNames map[string]bool
+	lookups      []string
+}
+
+func (sd *namedSd) SelectInstance(options *service_discovery.SelectInstanceOptions) (instance *service_discovery.ServiceInstance, err error) {
+	sd.mu.Lock()
+	sd.lookups = append(sd.lookups, options.ServiceName)
+	sd.mu.Unlock()
+	if !sd.serviceNames[options.ServiceName] {
+		err = errors.New("没有可用instance")
+		return
+	}
+	return sd.staticSd.SelectInstance(options)
+}
+
+func (sd *namedSd) OnInstanceDown(callback func(instance *service_discovery.ServiceInstance)) {}
+
+// 取出并清空查询记录
+func (sd *namedSd) takeLookups() (lookups []string) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	lookups, sd.lookups = sd.lookups, nil
+	return
+}
+
+func TestSocks5ServiceName(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	// 服务名不带端口，请求的端口与节点端口无关；已知的host:port服务按host:port发现
+	sd := &namedSd{serviceNames: map[string]bool{"search.svc": true, "redis.svc:6379": true}}
+	sd.instances = []*service_discovery.ServiceInstance{testInstance(t, "1", backend)}
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{
+		Sd:         sd,
+		RetryTimes: 1,
+		Socks5:     &Socks5Config{ListenAddr: "127.0.0.1:0", KnownServices: map[string]bool{"redis.svc:6379": true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := forwardProxy.listen("tcp", forwardProxy.config.Socks5.ListenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go forwardProxy.serveTcp(listener, forwardProxy.handleSocks5Conn)
+	defer func() {
+		ctx, cancelFunc := context.WithTimeout(context.TODO(), time.Second)
+		defer cancelFunc()
+		forwardProxy.Shutdown(ctx)
+	}()
+
+	dialer, _ := proxy.SOCKS5("tcp", listener.Addr().String(), nil, proxy.Direct)
+	client := &http.Client{Transport: &http.Transport{Dial: dialer.Dial, DisableKeepAlives: true}}
+	cases := []struct {
+		url     string
+		lookups string
+	}{
+		{"http://search.svc/", "[search.svc]"},
+		{"http://redis.svc:6379/", "[redis.svc:6379]"},
+		{backend.URL, "[127.0.0.1]"}, // 服务名不存在，只查1次即走DNS
+	}
+	for _, c := range cases {
+		resp, err := client.Get(c.url)
+		if err != nil {
+			t.Fatal(c.url, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "ok" {
+			t.Fatal(c.url, string(body))
+		}
+		if lookups := fmt.Sprint(sd.takeLookups()); lookups != c.lookups {
+			t.Fatal(c.url, lookups)
+		}
+	}
+}
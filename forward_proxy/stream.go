@@ -0,0 +1,113 @@
+package forward_proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// 请求体：不超过缓存阈值的body整体缓存，可以重放用于重试；否则流式转发，只能发送1次
+type requestBody struct {
+	spooled    []byte    // 缓存的body
+	stream     io.Reader // 超过阈值时，已读部分+剩余部分
+	replayable bool
+	streamRead int32 // atomic，流式body是否已开始发送
+}
+
+// 流式body的读取端，记录是否已开始发送
+type bodyStreamReader struct {
+	body *requestBody
+}
+
+func (reader *bodyStreamReader) Read(p []byte) (int, error) {
+	atomic.StoreInt32(&reader.body.streamRead, 1)
+	return reader.body.stream.Read(p)
+}
+
+// 流式body是否已开始发送
+func (body *requestBody) streamSent() bool {
+	return atomic.LoadInt32(&body.streamRead) == 1
+}
+
+// 读取请求体，最多缓存threshold字节
+func spoolRequestBody(req *http.Request, threshold int64) (body *requestBody, err error) {
+	body = &requestBody{}
+	if req.Body == nil || req.Body == http.NoBody {
+		body.replayable = true
+		return
+	}
+	if req.ContentLength > threshold || isGrpcRequest(req) { // 明确超过阈值，或gRPC流式调用，不必读
+		body.stream = req.Body
+		return
+	}
+
+	// 多读1个字节，判断是否超过阈值
+	var buf []byte
+	if buf, err = ioutil.ReadAll(io.LimitReader(req.Body, threshold+1)); err != nil {
+		return
+	}
+	if int64(len(buf)) <= threshold {
+		body.spooled = buf
+		body.replayable = true
+	} else {
+		body.stream = io.MultiReader(bytes.NewReader(buf), req.Body)
+	}
+	return
+}
+
+// 设置转发请求的body
+func (body *requestBody) attachTo(remoteReq *http.Request) {
+	if body.replayable {
+		if len(body.spooled) == 0 {
+			remoteReq.Body = nil
+			remoteReq.ContentLength = 0
+			return
+		}
+		remoteReq.Body = ioutil.NopCloser(bytes.NewReader(body.spooled))
+		remoteReq.ContentLength = int64(len(body.spooled))
+		remoteReq.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body.spooled)), nil
+		}
+	} else {
+		remoteReq.Body = ioutil.NopCloser(&bodyStreamReader{body: body})
+	}
+}
+
+// 应答是否需要边收边刷（SSE、长度未知的分块应答）
+func needFlush(resp *http.Response) bool {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return true
+	}
+	return resp.ContentLength < 0
+}
+
+// 流式拷贝应答体，区分读服务端出错与写客户端出错
+func copyResponseBody(dst http.ResponseWriter, src io.Reader, flush bool) (readErr error, writeErr error) {
+	flusher, _ := dst.(http.Flusher)
+	if !flush {
+		flusher = nil
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		size, err := src.Read(buf)
+		if size > 0 {
+			if _, writeErr = dst.Write(buf[:size]); writeErr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			readErr = err
+			return
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package forward_proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 服务发现总是失败，走DNS
+type dnsOnlySd struct {
+	service_discovery.IServiceDiscovery
+}
+
+func (sd *dnsOnlySd) SelectInstance(options *service_discovery.SelectInstanceOptions) (instance *service_discovery.ServiceInstance, err error) {
+	err = errors.New("没有可用instance")
+	return
+}
+
+func (sd *dnsOnlySd) OnInstanceDown(callback func(instance *service_discovery.ServiceInstance)) {}
+
+// 启动代理，返回走代理的client
+func newTestProxy(t *testing.T, config *ForwardProxyConfig) (forwardProxy *ForwardProxy, client *http.Client, closeFunc func()) {
+	if config.Sd == nil {
+		config.Sd = &dnsOnlySd{}
+	}
+	if config.RetryTimes == 0 {
+		config.RetryTimes = 3
+	}
+	forwardProxy, err := NewForwardProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(forwardProxy)
+	proxyUrl, _ := url.Parse(proxyServer.URL)
+	client = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	closeFunc = proxyServer.Close
+	return
+}
+
+func TestStreamLargeBody(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, req.Body)
+		fmt.Fprintf(rw, "%d", n)
+	}))
+	defer backend.Close()
+
+	_, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{SpoolThreshold: 1024})
+	defer closeFunc()
+
+	// 超过缓存阈值的body流式转发
+	size := 10 * 1024 * 1024
+	resp, err := client.Post(backend.URL, "application/octet-stream", strings.NewReader(strings.Repeat("x", size)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != fmt.Sprintf("%d", size) {
+		t.Fatal(string(body))
+	}
+}
+
+func TestStreamLargeBodyDialRetry(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, req.Body)
+		fmt.Fprintf(rw, "%d", n)
+	}))
+	defer backend.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	sd := &staticSd{instances: []*service_discovery.ServiceInstance{testInstance(t, "dead", dead), testInstance(t, "alive", backend)}}
+	_, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{Sd: sd, SpoolThreshold: 1024})
+	defer closeFunc()
+
+	// 建连失败时流式body尚未发送，换节点重试
+	size := 1024 * 1024
+	resp, err := client.Post("http://search.svc/upload", "application/octet-stream", strings.NewReader(strings.Repeat("x", size)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != fmt.Sprintf("%d", size) || resp.Header.Get("X-Proxy-Attempts") != "2" {
+		t.Fatal(string(body), resp.Header.Get("X-Proxy-Attempts"))
+	}
+}
+
+func TestStreamFlush(t *testing.T) {
+	release := make(chan byte)
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(rw, "data: first\n\n")
+		rw.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(rw, "data: second\n\n")
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	_, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{SpoolThreshold: 1024})
+	defer closeFunc()
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// 第1个事件在服务端结束前就到达
+	lineChan := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		lineChan <- line
+	}()
+	select {
+	case line := <-lineChan:
+		if line != "data: first\n" {
+			t.Fatal(line)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("事件未被及时刷出")
+	}
+}
+
+// 读取时报错的body
+type errReader struct{}
+
+func (reader errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("客户端发送了错误的body")
+}
+
+func TestSpoolError(t *testing.T) {
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{Sd: &dnsOnlySd{}, RetryTimes: 1, SpoolThreshold: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 读取请求body失败，明确回复错误而不是空的200
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/", ioutil.NopCloser(errReader{}))
+	rw := httptest.NewRecorder()
+	forwardProxy.handleHttpRequest(rw, req)
+	if rw.Code != http.StatusBadRequest || rw.Header().Get("X-Proxy-Error") != PROXY_ERROR_BAD_REQUEST {
+		t.Fatal(rw.Code, rw.Header())
+	}
+}
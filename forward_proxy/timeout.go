@@ -0,0 +1,136 @@
+package forward_proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 超时策略，0表示不限制
+type TimeoutPolicy struct {
+	Dial           time.Duration // 建连超时
+	TLSHandshake   time.Duration // TLS握手超时
+	ResponseHeader time.Duration // 单次尝试等待应答头的超时
+	Total          time.Duration // 整个请求的截止时间，含重试与读取应答
+	TunnelIdle     time.Duration // 隧道双向均无数据的空闲超时
+}
+
+// 等待应答头超时
+type responseHeaderTimeoutError struct{}
+
+func (err *responseHeaderTimeoutError) Error() string   { return "timeout awaiting response headers" }
+func (err *responseHeaderTimeoutError) Timeout() bool   { return true }
+func (err *responseHeaderTimeoutError) Temporary() bool { return true }
+
+// 服务的超时策略
+func (forwardProxy *ForwardProxy) timeoutPolicy(serviceName string) *TimeoutPolicy {
+	if policy, exist := forwardProxy.config.ServiceTimeout[serviceName]; exist {
+		return policy
+	}
+	return forwardProxy.config.Timeout
+}
+
+// 本次请求的总超时：服务配置、调用方的超时header、上一跳传来的剩余时间取最小者
+func (forwardProxy *ForwardProxy) totalTimeout(req *http.Request, policy *TimeoutPolicy) (timeout time.Duration) {
+	timeout = policy.Total
+	for _, name := range []string{forwardProxy.config.TimeoutHeader, forwardProxy.config.DeadlineHeader} {
+		if name == "" {
+			continue
+		}
+		if ms, err := strconv.ParseInt(req.Header.Get(name), 10, 64); err == nil && ms > 0 {
+			if fromHeader := time.Duration(ms) * time.Millisecond; timeout <= 0 || fromHeader < timeout {
+				timeout = fromHeader
+			}
+		}
+	}
+	return
+}
+
+// 把本次尝试的剩余时间传给服务端，以便下一跳及时放弃
+func (forwardProxy *ForwardProxy) propagateDeadline(remoteReq *http.Request) {
+	if forwardProxy.config.DeadlineHeader == "" {
+		return
+	}
+	deadline, ok := remoteReq.Context().Deadline()
+	if !ok {
+		return
+	}
+	remaining := time.Until(deadline) / time.Millisecond
+	if remaining < 1 {
+		remaining = 1
+	}
+	remoteReq.Header.Set(forwardProxy.config.DeadlineHeader, strconv.FormatInt(int64(remaining), 10))
+}
+
+// 转发请求携带的上游信息，供连接池建连时使用
+type upstreamContextKey struct{}
+
+type upstreamContext struct {
+	policy     *TimeoutPolicy
+	serverName string // TLS握手使用的服务名
+	h2c        bool   // 以明文HTTP/2转发
+}
+
+func withUpstreamContext(ctx context.Context, policy *TimeoutPolicy, serverName string, h2c bool) context.Context {
+	return context.WithValue(ctx, upstreamContextKey{}, &upstreamContext{policy: policy, serverName: serverName, h2c: h2c})
+}
+
+// 按服务的超时策略建连
+func (pool *upstreamPool) dialContext(ctx context.Context, network string, addr string) (conn net.Conn, err error) {
+	if upstream, ok := ctx.Value(upstreamContextKey{}).(*upstreamContext); ok && upstream.policy.Dial > 0 {
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = context.WithTimeout(ctx, upstream.policy.Dial)
+		defer cancelFunc()
+	}
+	return pool.dialer.DialContext(ctx, network, addr)
+}
+
+// 按服务的超时策略建立TLS连接，地址是节点IP，服务名从context中取
+func (pool *upstreamPool) dialTLSContext(ctx context.Context, network string, addr string) (conn net.Conn, err error) {
+	if conn, err = pool.dialContext(ctx, network, addr); err != nil {
+		return
+	}
+	serverName, _, _ := net.SplitHostPort(addr)
+	var handshakeTimeout time.Duration
+	if upstream, ok := ctx.Value(upstreamContextKey{}).(*upstreamContext); ok {
+		serverName = upstream.serverName
+		handshakeTimeout = upstream.policy.TLSHandshake
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName})
+	if handshakeTimeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	}
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+	conn = tlsConn
+	return
+}
+
+// 发送请求，超过timeout仍未收到应答头则中断该次尝试
+func (forwardProxy *ForwardProxy) roundTripWithHeaderTimeout(req *http.Request, timeout time.Duration, cancelFunc context.CancelFunc) (resp *http.Response, err error) {
+	if timeout <= 0 {
+		return forwardProxy.pool.RoundTrip(req)
+	}
+	var timedOut int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		cancelFunc()
+	})
+	resp, err = forwardProxy.pool.RoundTrip(req)
+	timer.Stop()
+	if atomic.LoadInt32(&timedOut) == 1 { // 已被中断，应答不可用
+		if err == nil {
+			resp.Body.Close()
+			resp = nil
+		}
+		err = &responseHeaderTimeoutError{}
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package forward_proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Proxy-Timeout-Ms") != "" {
+			t.Error("超时header泄漏")
+		}
+		select {
+		case <-time.After(3 * time.Second):
+		case <-req.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	_, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{
+		RetryTimes:    1,
+		Timeout:       &TimeoutPolicy{ResponseHeader: 100 * time.Millisecond},
+		TimeoutHeader: "X-Proxy-Timeout-Ms",
+	})
+	defer closeFunc()
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout || resp.Header.Get("X-Proxy-Error") != PROXY_ERROR_UPSTREAM_TIMEOUT {
+		t.Fatal(resp.StatusCode, resp.Header)
+	}
+
+	// 调用方缩短总超时
+	req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+	req.Header.Set("X-Proxy-Timeout-Ms", "50")
+	startTime := time.Now()
+	if resp, err = client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout || time.Since(startTime) > time.Second {
+		t.Fatal(resp.StatusCode, time.Since(startTime))
+	}
+}
+
+// 建立1对TCP连接
+func tcpPair(t *testing.T) (client net.Conn, server net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if client, err = net.Dial("tcp", listener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if server, err = listener.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	clientConn, clientPeer := tcpPair(t)
+	defer clientPeer.Close()
+	serverConn, serverPeer := tcpPair(t)
+	defer serverPeer.Close()
+
+	transferPair := NewTransferPair(clientConn, serverConn, 100*time.Millisecond)
+	done := make(chan byte)
+	go func() {
+		transferPair.DoTransfer()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("空闲隧道未被关闭")
+	}
+	if transferPair.ServerError() != nil {
+		t.Fatal("空闲超时不计为服务端错误")
+	}
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received <- req.Header.Get("X-Request-Timeout-Ms")
+	}))
+	defer backend.Close()
+
+	_, client, closeFunc := newTestProxy(t, &ForwardProxyConfig{DeadlineHeader: "X-Request-Timeout-Ms"})
+	defer closeFunc()
+
+	// 没有截止时间，不传递
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if value := <-received; value != "" {
+		t.Fatal(value)
+	}
+
+	// 上一跳的剩余时间，扣除本跳耗时后继续传递
+	req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+	req.Header.Set("X-Request-Timeout-Ms", "1000")
+	if resp, err = client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	value := <-received
+	if ms, err := strconv.Atoi(value); err != nil || ms <= 0 || ms > 1000 {
+		t.Fatal(value)
+	}
+}
@@ -17,22 +17,32 @@ type TransferPair struct {
 	clientEOF      bool
 	serverEOF      bool
 	lastError      error
-	lastActiveTime int64 // atomic
+	serverError    bool          // lastError是否来自服务端侧
+	lastActiveTime int64         // atomic
+	idleTimeout    time.Duration // 双向均无数据的空闲超时，0不限制
 }
 
-func NewTransferPair(clientConn net.Conn, serverConn net.Conn) (transferPair *TransferPair) {
+func NewTransferPair(clientConn net.Conn, serverConn net.Conn, idleTimeout time.Duration) (transferPair *TransferPair) {
 	transferPair = &TransferPair{
-		clientConn: clientConn,
-		serverConn: serverConn,
+		clientConn:  clientConn,
+		serverConn:  serverConn,
+		idleTimeout: idleTimeout,
 	}
 	transferPair.active()
 	return
 }
 
-// 因为错误而关闭连接对
+// 因为错误而关闭连接对，只保留首个错误
 func (transferPair *TransferPair) closeOnError(err error) {
+	transferPair.closeOnSideError(err, false)
+}
+
+func (transferPair *TransferPair) closeOnSideError(err error, fromServer bool) {
 	transferPair.mu.Lock()
-	transferPair.lastError = err
+	if transferPair.lastError == nil {
+		transferPair.lastError = err
+		transferPair.serverError = fromServer
+	}
 	transferPair.mu.Unlock()
 
 	transferPair.clientConn.Close()
@@ -41,7 +51,7 @@ func (transferPair *TransferPair) closeOnError(err error) {
 
 // 转发活跃
 func (transferPair *TransferPair) active() {
-	atomic.StoreInt64(&transferPair.lastActiveTime, time.Now().Unix())
+	atomic.StoreInt64(&transferPair.lastActiveTime, time.Now().UnixNano())
 }
 
 func (transferPair *TransferPair) client2server() {
@@ -54,7 +64,7 @@ func (transferPair *TransferPair) client2server() {
 		if size, err = transferPair.clientConn.Read(buf); size <= 0 {
 			// 判断错误类型
 			if err != io.EOF && err != io.ErrUnexpectedEOF {
-				transferPair.closeOnError(err)
+				transferPair.closeOnSideError(err, false)
 			} else {
 				transferPair.mu.Lock()
 				transferPair.clientEOF = true
@@ -67,7 +77,7 @@ func (transferPair *TransferPair) client2server() {
 		}
 		// 转发给server
 		if _, err = transferPair.serverConn.Write(buf[:size]); err != nil {
-			transferPair.closeOnError(err)
+			transferPair.closeOnSideError(err, true)
 			break
 		}
 		transferPair.active()
@@ -84,7 +94,7 @@ func (transferPair *TransferPair) server2client() {
 		if size, err = transferPair.serverConn.Read(buf); size <= 0 {
 			// 判断错误类型
 			if err != io.EOF && err != io.ErrUnexpectedEOF {
-				transferPair.closeOnError(err)
+				transferPair.closeOnSideError(err, true)
 			} else {
 				transferPair.mu.Lock()
 				transferPair.serverEOF = true
@@ -97,13 +107,23 @@ func (transferPair *TransferPair) server2client() {
 		}
 		// 转发给client
 		if _, err = transferPair.clientConn.Write(buf[:size]); err != nil {
-			transferPair.closeOnError(err)
+			transferPair.closeOnSideError(err, false)
 			break
 		}
 		transferPair.active()
 	}
 }
 
+// 服务端侧导致转发中断的错误，正常结束或客户端侧错误时返回nil
+func (transferPair *TransferPair) ServerError() (err error) {
+	transferPair.mu.Lock()
+	defer transferPair.mu.Unlock()
+	if transferPair.serverError {
+		err = transferPair.lastError
+	}
+	return
+}
+
 func (transferPair *TransferPair) DoTransfer() {
 	// client -> server
 	go transferPair.client2server()
@@ -124,13 +144,14 @@ func (transferPair *TransferPair) DoTransfer() {
 			return
 		}
 
-		// 半关闭持续
-		if (clientEOF && !serverEOF) || (!clientEOF && serverEOF) {
-			if time.Now().Unix()-atomic.LoadInt64(&transferPair.lastActiveTime) >= 3 { // 半关闭持续3秒，需要杀死连接对
-				transferPair.closeOnError(errors.New("半关闭状态持续太久"))
-				return
-			}
-		} else if clientEOF && serverEOF { // 全关闭退出
+		// 全关闭退出
+		if clientEOF && serverEOF {
+			return
+		}
+
+		// 空闲太久（含半关闭后另一侧迟迟不关），需要杀死连接对
+		if transferPair.idleTimeout > 0 && time.Duration(time.Now().UnixNano()-atomic.LoadInt64(&transferPair.lastActiveTime)) >= transferPair.idleTimeout {
+			transferPair.closeOnError(errors.New("隧道空闲超时"))
 			return
 		}
 
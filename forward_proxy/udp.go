@@ -0,0 +1,271 @@
+package forward_proxy
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+const (
+	UDP_SESSION_IDLE_TIMEOUT = 60 * time.Second // 会话默认空闲过期时间
+	UDP_MAX_PACKET_SIZE      = 65535
+)
+
+// 本地UDP端口到服务的静态映射，如StatsD、syslog
+type UdpMapping struct {
+	ListenAddr  string        // 本地监听地址，如127.0.0.1:8125
+	ServiceName string        // nacos服务名
+	IdleTimeout time.Duration // 会话双向均无包多久后过期，0取默认值
+}
+
+// 1个客户端地址与所选节点之间的会话
+type udpSession struct {
+	clientAddr     net.Addr
+	serverConn     net.Conn // 已connect到节点的UDP socket
+	ins            *service_discovery.ServiceInstance
+	startTime      time.Time
+	replyLatency   int64 // atomic，首个应答的耗时（纳秒），未应答为0
+	lastActiveTime int64 // atomic
+	closeOnce      sync.Once
+}
+
+// 会话活跃
+func (session *udpSession) active() {
+	atomic.StoreInt64(&session.lastActiveTime, time.Now().UnixNano())
+}
+
+// UDP会话表，按客户端地址保持节点亲和，与TCP的TransferPair对应
+type udpSessionTable struct {
+	mapping    *UdpMapping
+	packetConn net.PacketConn
+	mu         sync.Mutex
+	sessions   map[string]*udpSession // 客户端地址 -> 会话
+	resolving  map[string]bool        // 正在选择节点的客户端地址
+	closed     bool                   // 监听已结束，不再新建会话
+}
+
+// 取出绑定到某节点的会话
+func (table *udpSessionTable) sessionsOf(instance *service_discovery.ServiceInstance) (sessions []*udpSession) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, session := range table.sessions {
+		if session.ins.ServiceName == instance.ServiceName && session.ins.ID == instance.ID {
+			sessions = append(sessions, session)
+		}
+	}
+	return
+}
+
+// 取出全部会话
+func (table *udpSessionTable) allSessions() (sessions []*udpSession) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, session := range table.sessions {
+		sessions = append(sessions, session)
+	}
+	return
+}
+
+// 启动1个UDP端口映射，Shutdown后返回nil
+func (forwardProxy *ForwardProxy) RunUdpMapping(mapping *UdpMapping) (err error) {
+	var packetConn net.PacketConn
+	if packetConn, err = forwardProxy.listenPacket("udp", mapping.ListenAddr); err != nil {
+		return
+	}
+	return forwardProxy.serveUdp(packetConn, mapping)
+}
+
+// 接收客户端的包并转发到会话绑定的节点，直至监听被关闭
+func (forwardProxy *ForwardProxy) serveUdp(packetConn net.PacketConn, mapping *UdpMapping) (err error) {
+	table := &udpSessionTable{
+		mapping:    mapping,
+		packetConn: packetConn,
+		sessions:   make(map[string]*udpSession),
+		resolving:  make(map[string]bool),
+	}
+	forwardProxy.mu.Lock()
+	forwardProxy.udpTables[table] = struct{}{}
+	forwardProxy.mu.Unlock()
+	defer func() {
+		forwardProxy.mu.Lock()
+		delete(forwardProxy.udpTables, table)
+		forwardProxy.mu.Unlock()
+		table.mu.Lock()
+		table.closed = true
+		table.mu.Unlock()
+		for _, session := range table.allSessions() {
+			forwardProxy.closeUdpSession(table, session, nil)
+		}
+	}()
+
+	var buf = make([]byte, UDP_MAX_PACKET_SIZE)
+	for {
+		var size int
+		var clientAddr net.Addr
+		if size, clientAddr, err = packetConn.ReadFrom(buf); err != nil {
+			forwardProxy.mu.Lock()
+			isClosing := forwardProxy.isClosing
+			forwardProxy.mu.Unlock()
+			if isClosing {
+				err = nil
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return
+		}
+
+		key := clientAddr.String()
+		table.mu.Lock()
+		session := table.sessions[key]
+		resolving := table.resolving[key]
+		if session == nil && !resolving {
+			table.resolving[key] = true
+		}
+		table.mu.Unlock()
+		if session == nil {
+			// 服务发现可能等待首次加载，在协程中新建会话，不阻塞其他客户端的包；期间该客户端的后续包丢弃
+			if !resolving {
+				go forwardProxy.newUdpSession(table, clientAddr, append([]byte(nil), buf[:size]...))
+			}
+			continue
+		}
+		forwardProxy.udpClient2Server(table, session, buf[:size])
+	}
+}
+
+// 把客户端的包发往会话绑定的节点
+func (forwardProxy *ForwardProxy) udpClient2Server(table *udpSessionTable, session *udpSession, packet []byte) {
+	session.active()
+	if _, err := session.serverConn.Write(packet); err != nil { // 如节点端口不可达
+		forwardProxy.closeUdpSession(table, session, err)
+	}
+}
+
+// 为客户端地址选择节点新建会话，并发出首个包；没有可用节点则丢弃
+func (forwardProxy *ForwardProxy) newUdpSession(table *udpSessionTable, clientAddr net.Addr, firstPacket []byte) {
+	key := clientAddr.String()
+	giveUp := func() {
+		table.mu.Lock()
+		delete(table.resolving, key)
+		table.mu.Unlock()
+	}
+
+	options := &service_discovery.SelectInstanceOptions{ServiceName: table.mapping.ServiceName}
+	if host, _, err := net.SplitHostPort(key); err == nil {
+		options.ClientIp = host
+	}
+	ins, err := forwardProxy.config.Sd.SelectInstance(options)
+	if err != nil {
+		giveUp()
+		return
+	}
+	serverAddr := net.JoinHostPort(ins.Ip, strconv.FormatUint(ins.Port, 10))
+	serverConn, err := forwardProxy.dialer.Dial("udp", serverAddr)
+	if err != nil {
+		forwardProxy.reportOutcome(ins, OUTCOME_DIAL_FAIL, 0)
+		giveUp()
+		return
+	}
+	session := &udpSession{
+		clientAddr: clientAddr,
+		serverConn: serverConn,
+		ins:        ins,
+		startTime:  time.Now(),
+	}
+	session.active()
+
+	table.mu.Lock()
+	delete(table.resolving, key)
+	closed := table.closed
+	if !closed {
+		table.sessions[key] = session
+	}
+	table.mu.Unlock()
+	if closed { // 选择节点期间监听已结束
+		serverConn.Close()
+		forwardProxy.reportOutcome(ins, OUTCOME_CANCELED, 0)
+		return
+	}
+	go forwardProxy.udpServer2Client(table, session)
+	forwardProxy.udpClient2Server(table, session, firstPacket)
+}
+
+// 把节点的应答转回客户端，会话空闲过期或出错时结束
+func (forwardProxy *ForwardProxy) udpServer2Client(table *udpSessionTable, session *udpSession) {
+	idleTimeout := table.mapping.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = UDP_SESSION_IDLE_TIMEOUT
+	}
+
+	var buf = make([]byte, UDP_MAX_PACKET_SIZE)
+	for {
+		lastActiveTime := time.Unix(0, atomic.LoadInt64(&session.lastActiveTime))
+		session.serverConn.SetReadDeadline(lastActiveTime.Add(idleTimeout))
+		size, err := session.serverConn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				// 期间客户端仍在发包，则继续等待
+				if time.Since(time.Unix(0, atomic.LoadInt64(&session.lastActiveTime))) < idleTimeout {
+					continue
+				}
+				err = nil // 空闲过期
+			}
+			forwardProxy.closeUdpSession(table, session, err)
+			return
+		}
+		if atomic.LoadInt64(&session.replyLatency) == 0 {
+			atomic.StoreInt64(&session.replyLatency, int64(time.Since(session.startTime))|1)
+		}
+		session.active()
+		if _, err = table.packetConn.WriteTo(buf[:size], session.clientAddr); err != nil {
+			forwardProxy.closeUdpSession(table, session, nil) // 监听已关闭，不计节点失败
+			return
+		}
+	}
+}
+
+// 结束会话并上报节点结果：出错计为失败，收到过应答计为成功，否则（如单向的StatsD）不计成败
+func (forwardProxy *ForwardProxy) closeUdpSession(table *udpSessionTable, session *udpSession, err error) {
+	session.closeOnce.Do(func() {
+		key := session.clientAddr.String()
+		table.mu.Lock()
+		if table.sessions[key] == session {
+			delete(table.sessions, key)
+		}
+		table.mu.Unlock()
+		session.serverConn.Close()
+
+		replyLatency := time.Duration(atomic.LoadInt64(&session.replyLatency))
+		outcome := OUTCOME_CANCELED
+		if err != nil {
+			if outcome = classifyError(err); outcome == OUTCOME_CANCELED {
+				outcome = OUTCOME_RESET
+			}
+		} else if replyLatency > 0 {
+			outcome = OUTCOME_SUCCESS
+		}
+		forwardProxy.reportOutcome(session.ins, outcome, replyLatency)
+	})
+}
+
+// 节点下线或熔断时结束其会话，客户端的下一个包将重新选择节点
+func (forwardProxy *ForwardProxy) evictUdpSessions(instance *service_discovery.ServiceInstance) {
+	forwardProxy.mu.Lock()
+	tables := make([]*udpSessionTable, 0, len(forwardProxy.udpTables))
+	for table := range forwardProxy.udpTables {
+		tables = append(tables, table)
+	}
+	forwardProxy.mu.Unlock()
+
+	for _, table := range tables {
+		for _, session := range table.sessionsOf(instance) {
+			forwardProxy.closeUdpSession(table, session, nil)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package forward_proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 启动UDP映射，返回映射的本地地址
+func newTestUdpMapping(t *testing.T, sd service_discovery.IServiceDiscovery) (forwardProxy *ForwardProxy, addr string, closeFunc func()) {
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{Sd: sd, RetryTimes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := &UdpMapping{ListenAddr: "127.0.0.1:0", ServiceName: "statsd"}
+	packetConn, err := forwardProxy.listenPacket("udp", mapping.ListenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan byte)
+	go func() {
+		forwardProxy.serveUdp(packetConn, mapping)
+		close(served)
+	}()
+	addr = packetConn.LocalAddr().String()
+	closeFunc = func() {
+		ctx, cancelFunc := context.WithTimeout(context.TODO(), time.Second)
+		defer cancelFunc()
+		forwardProxy.Shutdown(ctx)
+		<-served
+	}
+	return
+}
+
+// 会话数
+func udpSessionCount(forwardProxy *ForwardProxy) (count int) {
+	forwardProxy.mu.Lock()
+	defer forwardProxy.mu.Unlock()
+	for table := range forwardProxy.udpTables {
+		count += len(table.allSessions())
+	}
+	return
+}
+
+func TestUdpMapping(t *testing.T) {
+	// 回显的服务端
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(buf[:n], addr)
+		}
+	}()
+	backendAddr := backend.LocalAddr().(*net.UDPAddr)
+
+	ins := &service_discovery.ServiceInstance{ServiceName: "statsd", ID: "1", Ip: backendAddr.IP.String(), Port: uint64(backendAddr.Port)}
+	sd := &markSd{staticSd: staticSd{instances: []*service_discovery.ServiceInstance{ins}}}
+	forwardProxy, addr, closeFunc := newTestUdpMapping(t, sd)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 1024)
+	for _, msg := range []string{"a:1|c", "b:2|c"} {
+		conn.Write([]byte(msg))
+		if n, err := conn.Read(buf); err != nil || string(buf[:n]) != msg {
+			t.Fatal(string(buf[:n]), err)
+		}
+	}
+	// 同一客户端地址复用1个会话
+	if count := udpSessionCount(forwardProxy); count != 1 {
+		t.Fatal(count)
+	}
+
+	// 节点熔断时结束会话，下一个包重新选择节点
+	forwardProxy.evictUdpSessions(ins)
+	if count := udpSessionCount(forwardProxy); count != 0 {
+		t.Fatal(count)
+	}
+	conn.Write([]byte("c:3|c"))
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "c:3|c" {
+		t.Fatal(string(buf[:n]), err)
+	}
+
+	// 收到过应答的会话结束时计为成功
+	closeFunc()
+	sd.markMu.Lock()
+	defer sd.markMu.Unlock()
+	if sd.success != 2 || sd.fail != 0 {
+		t.Fatal(sd.success, sd.fail)
+	}
+}
+
+func TestUdpMappingUnreachable(t *testing.T) {
+	// 占用后释放，得到1个无人监听的端口
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendAddr := backend.LocalAddr().(*net.UDPAddr)
+	backend.Close()
+
+	sd := &markSd{staticSd: staticSd{instances: []*service_discovery.ServiceInstance{
+		{ServiceName: "statsd", ID: "1", Ip: backendAddr.IP.String(), Port: uint64(backendAddr.Port)},
+	}}}
+	forwardProxy, addr, closeFunc := newTestUdpMapping(t, sd)
+	defer closeFunc()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("a:1|c"))
+
+	// 端口不可达计为节点失败，会话结束
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		sd.markMu.Lock()
+		fail := sd.fail
+		sd.markMu.Unlock()
+		if fail == 1 && udpSessionCount(forwardProxy) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(fail)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 首次服务发现阻塞直到release关闭，模拟服务首次加载
+type slowFirstSd struct {
+	staticSd
+	calls   int
+	release chan byte
+}
+
+func (sd *slowFirstSd) SelectInstance(options *service_discovery.SelectInstanceOptions) (instance *service_discovery.ServiceInstance, err error) {
+	sd.mu.Lock()
+	sd.calls++
+	first := sd.calls == 1
+	sd.mu.Unlock()
+	if first {
+		<-sd.release
+	}
+	return sd.staticSd.SelectInstance(options)
+}
+
+func (sd *slowFirstSd) OnInstanceDown(callback func(instance *service_discovery.ServiceInstance)) {}
+
+func TestUdpMappingSlowDiscovery(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(buf[:n], addr)
+		}
+	}()
+	backendAddr := backend.LocalAddr().(*net.UDPAddr)
+
+	sd := &slowFirstSd{release: make(chan byte)}
+	sd.instances = []*service_discovery.ServiceInstance{{ServiceName: "statsd", ID: "1", Ip: backendAddr.IP.String(), Port: uint64(backendAddr.Port)}}
+	_, addr, closeFunc := newTestUdpMapping(t, sd)
+	defer closeFunc()
+
+	// 第1个客户端等待服务发现期间，不影响第2个客户端
+	slowConn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slowConn.Close()
+	slowConn.Write([]byte("a:1|c"))
+	for i := 0; ; i++ {
+		sd.mu.Lock()
+		calls := sd.calls
+		sd.mu.Unlock()
+		if calls == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal(calls)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 1024)
+	conn.Write([]byte("b:2|c"))
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "b:2|c" {
+		t.Fatal(string(buf[:n]), err)
+	}
+
+	// 会话建立后发出第1个客户端的首个包
+	close(sd.release)
+	slowConn.SetDeadline(time.Now().Add(3 * time.Second))
+	if n, err := slowConn.Read(buf); err != nil || string(buf[:n]) != "a:1|c" {
+		t.Fatal(string(buf[:n]), err)
+	}
+}
@@ -0,0 +1,139 @@
+package forward_proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 是否协议升级请求（如WebSocket）
+func isUpgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range req.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 目标地址补全默认端口
+func hostWithPort(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, "80")
+}
+
+// HTTP协议升级：建连发送请求，服务端回复101后接管双方连接，交给隧道转发
+func (forwardProxy *ForwardProxy) handleUpgradeRequest(rw http.ResponseWriter, req *http.Request) {
+	timeouts := forwardProxy.timeoutPolicy(req.Host)
+
+	// 建立到服务端的TCP连接
+	serverConn, serverIns, dstHost, dialLatency, err := forwardProxy.dialUpstream(req.Context(), selectOptions(req), hostWithPort(req.Host), timeouts)
+	if err != nil {
+		if req.Context().Err() == nil {
+			writeProxyError(rw, classifyProxyError(err), dstHost, err)
+		}
+		return
+	}
+	defer serverConn.Close()
+
+	// 构造转发请求：去掉逐跳header，再带上升级协议
+	upgrade := req.Header.Get("Upgrade")
+	remoteReq := req.Clone(req.Context())
+	forwardProxy.rewriteRequestHeader(remoteReq, req)
+	forwardProxy.setIdentityHeader(remoteReq, serverIns)
+	remoteReq.Header.Set("Connection", "Upgrade")
+	remoteReq.Header.Set("Upgrade", upgrade)
+
+	// 发送请求并等待应答头
+	startTime := time.Now()
+	if timeouts.ResponseHeader > 0 {
+		serverConn.SetDeadline(startTime.Add(timeouts.ResponseHeader))
+	}
+	serverReader := bufio.NewReader(serverConn)
+	var resp *http.Response
+	if err = remoteReq.Write(serverConn); err == nil {
+		resp, err = http.ReadResponse(serverReader, remoteReq)
+	}
+	if err != nil {
+		forwardProxy.reportOutcome(serverIns, classifyError(err), time.Since(startTime))
+		writeProxyError(rw, classifyProxyError(err), dstHost, err)
+		return
+	}
+	defer resp.Body.Close()
+	serverConn.SetDeadline(time.Time{})
+	latency := dialLatency + time.Since(startTime)
+
+	// 服务端拒绝升级，按普通应答转发
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		readErr, writeErr := forwardProxy.copyResponse(rw, resp)
+		outcome := forwardProxy.classifyHttpOutcome(resp, nil)
+		if writeErr != nil {
+			outcome = OUTCOME_CANCELED
+		} else if readErr != nil {
+			outcome = OUTCOME_RESET
+		}
+		forwardProxy.reportOutcome(serverIns, outcome, latency)
+		return
+	}
+
+	// 接管客户端侧的TCP连接
+	var clientConn net.Conn
+	var clientBuf *bufio.ReadWriter
+	if hijacker, ok := rw.(http.Hijacker); ok {
+		if clientConn, clientBuf, err = hijacker.Hijack(); err != nil {
+			forwardProxy.reportOutcome(serverIns, OUTCOME_CANCELED, latency)
+			return
+		}
+		defer clientConn.Close()
+	} else {
+		forwardProxy.reportOutcome(serverIns, OUTCOME_CANCELED, latency)
+		return
+	}
+
+	// 回复101，保留升级相关header
+	upgrade = resp.Header.Get("Upgrade")
+	removeHopHeaders(resp.Header)
+	resp.Header.Set("Connection", "Upgrade")
+	resp.Header.Set("Upgrade", upgrade)
+	if !forwardProxy.config.DisableForwardedHeaders {
+		addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor, forwardProxy.config.ProxyName)
+	}
+	if err = writeUpgradeResponse(clientConn, resp, serverReader, clientBuf, serverConn); err != nil {
+		forwardProxy.reportOutcome(serverIns, OUTCOME_CANCELED, latency)
+		return
+	}
+
+	forwardProxy.transferTunnel(req.Host, clientConn, serverConn, serverIns, latency, timeouts.TunnelIdle)
+}
+
+// 回复101，并把双方已读入缓冲区的数据转发给对端
+func writeUpgradeResponse(clientConn net.Conn, resp *http.Response, serverReader *bufio.Reader, clientBuf *bufio.ReadWriter, serverConn net.Conn) (err error) {
+	writer := bufio.NewWriter(clientConn)
+	fmt.Fprintf(writer, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(writer)
+	writer.WriteString("\r\n")
+	// 服务端紧随101发来的数据
+	if buffered := serverReader.Buffered(); buffered > 0 {
+		data, _ := serverReader.Peek(buffered)
+		writer.Write(data)
+	}
+	if err = writer.Flush(); err != nil {
+		return
+	}
+	// 客户端紧随请求发来的数据
+	if buffered := clientBuf.Reader.Buffered(); buffered > 0 {
+		data, _ := clientBuf.Reader.Peek(buffered)
+		_, err = serverConn.Write(data)
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package forward_proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUpgrade(t *testing.T) {
+	// 升级为echo协议
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Upgrade") != "echo" || req.Header.Get("Proxy-Connection") != "" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, buf, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		buf.Flush()
+		io.Copy(conn, buf)
+	}))
+	defer backend.Close()
+
+	forwardProxy, err := NewForwardProxy(&ForwardProxyConfig{Sd: &dnsOnlySd{}, RetryTimes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(forwardProxy)
+	defer proxyServer.Close()
+
+	conn, err := net.Dial("tcp", proxyServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	// 握手请求后紧跟数据
+	fmt.Fprintf(conn, "GET %s/ws HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: echo\r\nProxy-Connection: keep-alive\r\n\r\nhello", backend.URL, backend.Listener.Addr())
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatal(resp.StatusCode, resp.Header)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(reader, buf); err != nil || string(buf) != "hello" {
+		t.Fatal(string(buf), err)
+	}
+	conn.Write([]byte("world"))
+	if _, err = io.ReadFull(reader, buf); err != nil || string(buf) != "world" {
+		t.Fatal(string(buf), err)
+	}
+}
@@ -2,5 +2,7 @@ module github.com/owenliang/nacos-reverse-proxy
 
 go 1.14
 
-require github.com/nacos-group/nacos-sdk-go v1.0.7
- 
\ No newline at end of file
+require (
+	github.com/nacos-group/nacos-sdk-go v1.0.7
+	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
+)
@@ -0,0 +1,92 @@
+package lifecycle
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/app_register"
+	"github.com/owenliang/nacos-reverse-proxy/forward_proxy"
+	"github.com/owenliang/nacos-reverse-proxy/probe"
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 配置
+type LifecycleConfig struct {
+	Sd             service_discovery.IServiceDiscovery         // 服务注册
+	AppRegister    *app_register.AppRegister                   // 应用注册器，为nil表示未开启注册
+	UnRegister     *service_discovery.UnRegisterServiceOptions // 取消注册参数
+	AppProber      probe.Prober                                // 应用探测器，用于等待应用退出
+	ProbeInterval  time.Duration                               // 等待应用退出时的探测间隔
+	ProbeTimeout   time.Duration                               // 单次探测超时
+	Proxy          *forward_proxy.ForwardProxy                 // 正向代理
+	DrainTimeout   time.Duration                               // 等待请求与隧道结束的最长时间
+	AppExitTimeout time.Duration                               // 等待应用退出的最长时间
+}
+
+// 进程生命周期管理，按README中的kubernetes退出顺序执行
+type Lifecycle struct {
+	config *LifecycleConfig
+}
+
+func NewLifecycle(lifecycleConfig *LifecycleConfig) (lifecycle *Lifecycle, err error) {
+	lifecycle = &Lifecycle{config: lifecycleConfig}
+	return
+}
+
+// 阻塞直到收到退出信号，然后执行退出流程
+func (lifecycle *Lifecycle) WaitForSignal() {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signalChan
+	signal.Stop(signalChan)
+
+	log.Printf("收到信号%v，开始退出", sig)
+	lifecycle.Shutdown()
+}
+
+// 退出流程：取消注册 -> 排空请求与隧道 -> 等待应用退出
+func (lifecycle *Lifecycle) Shutdown() {
+	config := lifecycle.config
+
+	// 1, 立即取消注册（先停止探测，避免被重新注册）
+	if config.AppRegister != nil {
+		config.AppRegister.Stop()
+		if err := config.Sd.UnRegisterService(config.UnRegister); err != nil {
+			log.Printf("取消注册失败: %v", err)
+		}
+	}
+
+	// 2, 排空进行中的HTTP请求与隧道
+	if config.Proxy != nil {
+		ctx, cancelFunc := context.WithTimeout(context.TODO(), config.DrainTimeout)
+		if err := config.Proxy.Shutdown(ctx); err != nil {
+			log.Printf("排空请求超时: %v", err)
+		}
+		cancelFunc()
+	}
+
+	// 3, 探活应用端口，直到应用退出
+	if config.AppProber != nil {
+		if !lifecycle.waitAppExit() {
+			log.Printf("等待应用退出超时")
+		}
+	}
+}
+
+// 应用探测失败即认为已退出
+func (lifecycle *Lifecycle) waitAppExit() bool {
+	config := lifecycle.config
+
+	deadline := time.Now().Add(config.AppExitTimeout)
+	for time.Now().Before(deadline) {
+		if err := probe.ProbeWithTimeout(config.AppProber, config.ProbeTimeout); err != nil {
+			return true
+		}
+		time.Sleep(config.ProbeInterval)
+	}
+	return false
+}
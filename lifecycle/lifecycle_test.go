@@ -0,0 +1,247 @@
+package lifecycle
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/app_register"
+	"github.com/owenliang/nacos-reverse-proxy/forward_proxy"
+	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
+)
+
+// 按顺序记录退出流程中的事件
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (log *eventLog) add(event string) {
+	log.mu.Lock()
+	log.events = append(log.events, event)
+	log.mu.Unlock()
+}
+
+func (log *eventLog) String() string {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return fmt.Sprint(log.events)
+}
+
+// 记录取消注册的服务发现，发现总是失败（走DNS）
+type fakeSd struct {
+	service_discovery.IServiceDiscovery
+	log          *eventLog
+	onUnRegister func()
+}
+
+func (sd *fakeSd) RegisterService(options *service_discovery.RegisterServiceOptions) (err error) {
+	return
+}
+
+func (sd *fakeSd) UpdateService(options *service_discovery.UpdateServiceOptions) (err error) {
+	return
+}
+
+func (sd *fakeSd) UnRegisterService(options *service_discovery.UnRegisterServiceOptions) (err error) {
+	sd.log.add("unregister")
+	if sd.onUnRegister != nil {
+		sd.onUnRegister()
+	}
+	return
+}
+
+func (sd *fakeSd) SelectInstance(options *service_discovery.SelectInstanceOptions) (instance *service_discovery.ServiceInstance, err error) {
+	err = errors.New("没有可用instance")
+	return
+}
+
+func (sd *fakeSd) OnInstanceDown(callback func(instance *service_discovery.ServiceInstance)) {}
+
+// 前aliveTimes次探测成功，之后失败（应用已退出）
+type fakeProber struct {
+	log        *eventLog
+	mu         sync.Mutex
+	aliveTimes int
+}
+
+func (prober *fakeProber) Probe(ctx context.Context) (err error) {
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	if prober.log != nil {
+		prober.log.add("probe")
+	}
+	if prober.aliveTimes <= 0 {
+		return errors.New("connection refused")
+	}
+	prober.aliveTimes--
+	return
+}
+
+// 启动代理及应用注册
+func newTestLifecycle(t *testing.T, sd *fakeSd, appProber *fakeProber, drainTimeout time.Duration, appExitTimeout time.Duration) (lifecycle *Lifecycle, proxyUrl *url.URL) {
+	// 先占用1个端口作为代理地址
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listenAddr := listener.Addr().String()
+	listener.Close()
+
+	proxy, err := forward_proxy.NewForwardProxy(&forward_proxy.ForwardProxyConfig{ListenAddr: listenAddr, Sd: sd, RetryTimes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Run()
+	// 等待代理开始监听
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", listenAddr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	appRegister, err := app_register.NewAppRegister(&app_register.AppRegisterConfig{
+		ServiceName:      "app",
+		Ip:               "127.0.0.1",
+		Port:             8080,
+		Weight:           1,
+		Prober:           &fakeProber{aliveTimes: 1 << 30},
+		ProbeInterval:    time.Hour,
+		ProbeTimeout:     time.Second,
+		SuccessThreshold: 1,
+		FailThreshold:    1,
+		Sd:               sd,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go appRegister.Run()
+
+	lifecycle, err = NewLifecycle(&LifecycleConfig{
+		Sd:             sd,
+		AppRegister:    appRegister,
+		UnRegister:     &service_discovery.UnRegisterServiceOptions{ServiceName: "app", Ip: "127.0.0.1", Port: 8080},
+		AppProber:      appProber,
+		ProbeInterval:  10 * time.Millisecond,
+		ProbeTimeout:   time.Second,
+		Proxy:          proxy,
+		DrainTimeout:   drainTimeout,
+		AppExitTimeout: appExitTimeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyUrl, _ = url.Parse("http://" + listenAddr)
+	return
+}
+
+func TestShutdownOrder(t *testing.T) {
+	log := &eventLog{}
+	release := make(chan byte)
+	received := make(chan byte)
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(received)
+		<-release
+		log.add("request-done")
+		rw.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	// 取消注册之后才放行进行中的请求
+	sd := &fakeSd{log: log, onUnRegister: func() {
+		time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	}}
+	lifecycle, proxyUrl := newTestLifecycle(t, sd, &fakeProber{log: log, aliveTimes: 2}, 3*time.Second, 3*time.Second)
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	bodyChan := make(chan string, 1)
+	go func() {
+		resp, err := client.Get(backend.URL)
+		if err != nil {
+			bodyChan <- err.Error()
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		bodyChan <- string(body)
+	}()
+	<-received
+
+	// 取消注册 -> 排空进行中的请求 -> 探测直到应用退出
+	lifecycle.Shutdown()
+	if body := <-bodyChan; body != "ok" {
+		t.Fatal(body)
+	}
+	if events := log.String(); events != "[unregister request-done probe probe probe]" {
+		t.Fatal(events)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	// 不主动结束的TCP服务端
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() { // 直到客户端侧关闭
+				ioutil.ReadAll(conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	sd := &fakeSd{log: &eventLog{}}
+	lifecycle, proxyUrl := newTestLifecycle(t, sd, &fakeProber{aliveTimes: 1 << 30}, 100*time.Millisecond, 200*time.Millisecond)
+
+	// 建立1条隧道
+	clientConn, err := net.Dial("tcp", proxyUrl.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	fmt.Fprintf(clientConn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", backend.Addr(), backend.Addr())
+	reader := bufio.NewReader(clientConn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(resp, err)
+	}
+
+	// 排空超时强制关闭隧道，应用一直未退出则等到超时为止
+	startTime := time.Now()
+	lifecycle.Shutdown()
+	elapsed := time.Since(startTime)
+	if elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatal(elapsed)
+	}
+	clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = reader.ReadByte(); err == nil || isTimeout(err) {
+		t.Fatal(err)
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
-	"time"
+	"fmt"
+	"strings"
 
+	"github.com/owenliang/nacos-reverse-proxy/admin"
+	"github.com/owenliang/nacos-reverse-proxy/app_register"
 	"github.com/owenliang/nacos-reverse-proxy/flags"
+	"github.com/owenliang/nacos-reverse-proxy/lifecycle"
+	"github.com/owenliang/nacos-reverse-proxy/probe"
 
 	"github.com/owenliang/nacos-reverse-proxy/forward_proxy"
 	"github.com/owenliang/nacos-reverse-proxy/service_discovery"
@@ -23,24 +28,186 @@ func main() {
 		Cluster:    flags.Cluster,
 		Group:      flags.Group,
 		NacosNodes: flags.NacosNodes,
+
+		ReconcileInterval: flags.ReconcileInterval,
+
+		LoadBalance:        &service_discovery.LoadBalanceConfig{Policy: flags.LoadBalance, HashHeader: flags.HashHeader},
+		ServiceLoadBalance: flags.ServiceLoadBalance(),
+
+		HealthCheck: &service_discovery.HealthCheckConfig{
+			Type:             flags.UpstreamCheck,
+			Path:             flags.UpstreamCheckPath,
+			Interval:         flags.UpstreamCheckInterval,
+			Timeout:          flags.UpstreamCheckTimeout,
+			SuccessThreshold: flags.UpstreamCheckSuccess,
+			FailThreshold:    flags.UpstreamCheckFail,
+		},
+
+		IdleTimeout:       flags.ServiceIdleTimeout,
+		NegativeTTL:       flags.NegativeTTL,
+		MaxServices:       flags.MaxServices,
+		SnapshotDir:       flags.SnapshotDir,
+		SnapshotRetention: flags.SnapshotRetention,
 	})
 	if err != nil {
 		panic(err)
 	}
 
+	// SOCKS5
+	var socks5Config *forward_proxy.Socks5Config
+	if flags.Socks5ListenAddr != "" {
+		socks5Config = &forward_proxy.Socks5Config{
+			ListenAddr:    flags.Socks5ListenAddr,
+			Username:      flags.Socks5Username,
+			Password:      flags.Socks5Password,
+			KnownServices: flags.KnownServices,
+		}
+	}
+
+	// 入口
+	var ingressConfig *forward_proxy.IngressConfig
+	if flags.IngressListenAddr != "" {
+		ingressConfig = &forward_proxy.IngressConfig{
+			ListenAddr: flags.IngressListenAddr,
+			Routes:     flags.IngressRoutes,
+		}
+	}
+
 	// 正向代理
 	proxy, err := forward_proxy.NewForwardProxy(&forward_proxy.ForwardProxyConfig{
-		ListenAddr: flags.ListenAddr,
-		RetryTimes: flags.RetryTimes,
-		Sd:         sd,
+		ListenAddr:   flags.ListenAddr,
+		RetryTimes:   flags.RetryTimes,
+		Retry:        flags.Retry,
+		ServiceRetry: flags.ServiceRetry,
+		RetryBudget:  flags.RetryBudget,
+		ServiceHedge: flags.ServiceHedge,
+
+		Timeout:        flags.Timeout,
+		ServiceTimeout: flags.ServiceTimeout,
+		TimeoutHeader:  flags.TimeoutHeader,
+		DeadlineHeader: flags.DeadlineHeader,
+		Sd:             sd,
+
+		FailStatusCodes: flags.FailStatusCodes,
+		SpoolThreshold:  flags.SpoolKB * 1024,
+
+		Pool: &forward_proxy.PoolConfig{
+			MaxIdleConnsPerHost: flags.UpstreamMaxIdle,
+			IdleConnTimeout:     flags.UpstreamIdleTimeout,
+			MaxConnLifetime:     flags.UpstreamMaxLifetime,
+		},
+		H2cServices: flags.H2cServices,
+
+		ProxyName:               flags.ProxyName,
+		DisableForwardedHeaders: flags.DisableForwardedHeaders,
+		IdentityHeader:          flags.IdentityHeader,
+		ConnectPorts:            flags.ConnectPortSet,
+
+		Socks5:  socks5Config,
+		Ingress: ingressConfig,
 	})
 	if err != nil {
 		panic(err)
 	}
-	go proxy.Run()
+	go func() {
+		if err := proxy.Run(); err != nil {
+			panic(err)
+		}
+	}()
+	if socks5Config != nil {
+		go func() {
+			if err := proxy.RunSocks5(); err != nil {
+				panic(err)
+			}
+		}()
+	}
+	if ingressConfig != nil {
+		go func() {
+			if err := proxy.RunIngress(); err != nil {
+				panic(err)
+			}
+		}()
+	}
+	for _, mapping := range flags.PortMappings {
+		go func(mapping *forward_proxy.PortMapping) {
+			if err := proxy.RunPortMapping(mapping); err != nil {
+				panic(err)
+			}
+		}(mapping)
+	}
+	for _, mapping := range flags.UdpMappings {
+		go func(mapping *forward_proxy.UdpMapping) {
+			if err := proxy.RunUdpMapping(mapping); err != nil {
+				panic(err)
+			}
+		}(mapping)
+	}
 
-	// 探测应用健康，完成服务注册，退出前取消服务注册，退出前确保应用先退出。
-	for {
-		time.Sleep(1 * time.Second)
+	// 管理接口
+	if flags.AdminListenAddr != "" {
+		var adminServer *admin.AdminServer
+		if adminServer, err = admin.NewAdminServer(&admin.AdminServerConfig{ListenAddr: flags.AdminListenAddr, Nsd: sd}); err != nil {
+			panic(err)
+		}
+		go func() {
+			if err := adminServer.Run(); err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	lifecycleConfig := &lifecycle.LifecycleConfig{
+		Sd:             sd,
+		ProbeInterval:  flags.ProbeInterval,
+		ProbeTimeout:   flags.ProbeTimeout,
+		Proxy:          proxy,
+		DrainTimeout:   flags.DrainTimeout,
+		AppExitTimeout: flags.AppExitTimeout,
+	}
+
+	// 探测应用健康，完成服务注册
+	if flags.ServiceName != "" {
+		var prober probe.Prober
+		prober, err = probe.NewProber(&probe.ProberOptions{
+			Type:    flags.ProbeType,
+			Addr:    fmt.Sprintf("%s:%d", flags.AppIp, flags.AppPort),
+			Path:    flags.ProbePath,
+			Command: strings.Fields(flags.ProbeCommand),
+		})
+		if err != nil {
+			panic(err)
+		}
+		var appRegister *app_register.AppRegister
+		appRegister, err = app_register.NewAppRegister(&app_register.AppRegisterConfig{
+			ServiceName:      flags.ServiceName,
+			Ip:               flags.AppIp,
+			Port:             uint64(flags.AppPort),
+			Weight:           flags.AppWeight,
+			Prober:           prober,
+			ProbeInterval:    flags.ProbeInterval,
+			ProbeTimeout:     flags.ProbeTimeout,
+			SuccessThreshold: flags.ProbeSuccessThreshold,
+			FailThreshold:    flags.ProbeFailThreshold,
+			Sd:               sd,
+		})
+		if err != nil {
+			panic(err)
+		}
+		go appRegister.Run()
+
+		lifecycleConfig.AppRegister = appRegister
+		lifecycleConfig.AppProber = prober
+		lifecycleConfig.UnRegister = &service_discovery.UnRegisterServiceOptions{
+			ServiceName: flags.ServiceName,
+			Ip:          flags.AppIp,
+			Port:        uint64(flags.AppPort),
+		}
+	}
+
+	// 收到退出信号后取消服务注册，排空请求，确保应用先退出。
+	lc, err := lifecycle.NewLifecycle(lifecycleConfig)
+	if err != nil {
+		panic(err)
 	}
+	lc.WaitForSignal()
 }
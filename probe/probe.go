@@ -0,0 +1,117 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+const (
+	PROBE_TYPE_HTTP = "http" // HTTP GET探测
+	PROBE_TYPE_TCP  = "tcp"  // TCP建连探测
+	PROBE_TYPE_EXEC = "exec" // 执行命令探测
+)
+
+// 探测器
+type Prober interface {
+	// 探测一次，返回nil表示健康
+	Probe(ctx context.Context) (err error)
+}
+
+// 探测配置
+type ProberOptions struct {
+	Type    string   // 探测类型
+	Addr    string   // ip:port（http/tcp）
+	Path    string   // http探测路径
+	Command []string // exec探测命令
+}
+
+// TCP探测：能建连即健康
+type TcpProber struct {
+	addr   string
+	dialer *net.Dialer
+}
+
+func (tcpProber *TcpProber) Probe(ctx context.Context) (err error) {
+	var conn net.Conn
+	if conn, err = tcpProber.dialer.DialContext(ctx, "tcp", tcpProber.addr); err != nil {
+		return
+	}
+	conn.Close()
+	return
+}
+
+// HTTP探测：GET返回2xx/3xx即健康
+type HttpProber struct {
+	url    string
+	client *http.Client
+}
+
+func (httpProber *HttpProber) Probe(ctx context.Context) (err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, httpProber.url, nil); err != nil {
+		return
+	}
+	var resp *http.Response
+	if resp, err = httpProber.client.Do(req); err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		err = fmt.Errorf("http探测状态码异常: %d", resp.StatusCode)
+	}
+	return
+}
+
+// EXEC探测：命令退出码为0即健康
+type ExecProber struct {
+	command []string
+}
+
+func (execProber *ExecProber) Probe(ctx context.Context) (err error) {
+	err = exec.CommandContext(ctx, execProber.command[0], execProber.command[1:]...).Run()
+	return
+}
+
+// 新建探测器
+func NewProber(options *ProberOptions) (prober Prober, err error) {
+	switch options.Type {
+	case PROBE_TYPE_TCP:
+		prober = &TcpProber{addr: options.Addr, dialer: &net.Dialer{}}
+	case PROBE_TYPE_HTTP:
+		path := options.Path
+		if path == "" {
+			path = "/"
+		}
+		prober = &HttpProber{
+			url: fmt.Sprintf("http://%s%s", options.Addr, path),
+			client: &http.Client{
+				Transport: &http.Transport{DisableKeepAlives: true, Proxy: nil},
+				CheckRedirect: func(req *http.Request, via []*http.Request) error { // 不跟随跳转，3xx即算健康
+					return http.ErrUseLastResponse
+				},
+			},
+		}
+	case PROBE_TYPE_EXEC:
+		if len(options.Command) == 0 {
+			err = errors.New("exec探测命令为空")
+			return
+		}
+		prober = &ExecProber{command: options.Command}
+	default:
+		err = fmt.Errorf("不支持的探测类型: %s", options.Type)
+	}
+	return
+}
+
+// 带超时的探测
+func ProbeWithTimeout(prober Prober, timeout time.Duration) (err error) {
+	ctx, cancelFunc := context.WithTimeout(context.TODO(), timeout)
+	defer cancelFunc()
+	err = prober.Probe(ctx)
+	return
+}
@@ -0,0 +1,131 @@
+package service_discovery
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/probe"
+)
+
+// 主动健康检查配置
+type HealthCheckConfig struct {
+	Type             string        // 探测类型：tcp|http，为空表示不开启
+	Path             string        // http探测路径
+	Interval         time.Duration // 探测间隔
+	Timeout          time.Duration // 单次探测超时
+	SuccessThreshold int           // 连续成功多少次恢复
+	FailThreshold    int           // 连续失败多少次摘除
+}
+
+// 检查配置
+func checkHealthCheckConfig(config *HealthCheckConfig) (err error) {
+	switch config.Type {
+	case "":
+		return
+	case probe.PROBE_TYPE_TCP, probe.PROBE_TYPE_HTTP:
+	default:
+		err = fmt.Errorf("不支持的健康检查类型: %s", config.Type)
+		return
+	}
+	if config.Interval <= 0 || config.Timeout <= 0 || config.SuccessThreshold <= 0 || config.FailThreshold <= 0 {
+		err = fmt.Errorf("健康检查参数非法")
+	}
+	return
+}
+
+// 节点的主动探测状态，实例列表更新时迁移到新实例对象
+type instanceHealth struct {
+	mu           sync.Mutex
+	healthy      bool         // 是否健康，初始视为健康
+	successCount int          // 连续成功次数
+	failCount    int          // 连续失败次数
+	lastProbe    time.Time    // 最近一次探测时间
+	lastError    string       // 最近一次探测错误
+	prober       probe.Prober // 探测器，各轮复用（HTTP探测器带有自己的Transport）
+	proberAddr   string       // 探测器对应的ip:port
+}
+
+func newInstanceHealth() *instanceHealth {
+	return &instanceHealth{healthy: true}
+}
+
+// 记录一次探测结果，返回本次是否由健康变为不健康
+func (health *instanceHealth) record(err error, config *HealthCheckConfig) (down bool) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	health.lastProbe = time.Now()
+	if err == nil {
+		health.lastError = ""
+		health.successCount++
+		health.failCount = 0
+		if !health.healthy && health.successCount >= config.SuccessThreshold {
+			health.healthy = true
+		}
+	} else {
+		health.lastError = err.Error()
+		health.failCount++
+		health.successCount = 0
+		if health.healthy && health.failCount >= config.FailThreshold {
+			health.healthy = false
+			down = true
+		}
+	}
+	return
+}
+
+// 取节点的探测器，首次或地址变化时新建
+func (health *instanceHealth) proberOf(addr string, config *HealthCheckConfig) (prober probe.Prober, err error) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	if health.prober != nil && health.proberAddr == addr {
+		return health.prober, nil
+	}
+	if prober, err = probe.NewProber(&probe.ProberOptions{Type: config.Type, Addr: addr, Path: config.Path}); err != nil {
+		return
+	}
+	health.prober = prober
+	health.proberAddr = addr
+	return
+}
+
+func (health *instanceHealth) isHealthy() bool {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	return health.healthy
+}
+
+// 持续探测服务的所有节点
+func (nacosService *NacosService) healthCheckForever() {
+	config := nacosService.nsd.sdConfig.HealthCheck
+
+	for {
+		nacosService.mu.Lock()
+		instances := nacosService.instances
+		nacosService.mu.Unlock()
+
+		// 并发探测，等待本轮结束
+		wg := sync.WaitGroup{}
+		for _, ins := range instances {
+			wg.Add(1)
+			go func(ins *NacosInstance) {
+				defer wg.Done()
+				prober, err := ins.health.proberOf(fmt.Sprintf("%s:%d", ins.ip, ins.port), config)
+				if err == nil {
+					err = probe.ProbeWithTimeout(prober, config.Timeout)
+				}
+				if ins.health.record(err, config) {
+					nacosService.nsd.notifyInstanceDown(ins)
+				}
+			}(ins)
+		}
+		wg.Wait()
+
+		// 被淘汰则退出
+		if !nacosService.sleep(config.Interval) {
+			return
+		}
+	}
+}
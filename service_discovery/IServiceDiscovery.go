@@ -1,5 +1,10 @@
 package service_discovery
 
+import (
+	"net/http"
+	"time"
+)
+
 // 服务注册
 type RegisterServiceOptions struct {
 	ServiceName string
@@ -28,12 +33,17 @@ type UpdateServiceOptions struct {
 // 服务发现
 type SelectInstanceOptions struct {
 	ServiceName string
+	Header      http.Header // 请求头，供一致性哈希使用
+	ClientIp    string      // 客户端IP，供一致性哈希使用
+
+	ExcludeIDs map[string]bool // 本次请求已尝试过的节点，重试时尽量避开
 }
 
 // 节点标记
 type MarkInstanceOptions struct {
 	ServiceName string
 	ID          string
+	Latency     time.Duration // 请求耗时，<=0表示不统计延迟
 }
 
 // 服务节点
@@ -55,7 +65,7 @@ type IServiceDiscovery interface {
 	// 更新服务信息
 	UpdateService(options *UpdateServiceOptions) (err error)
 
-	// 服务发现节点
+	// 服务发现节点，选中的节点需要调用方以MarkInstanceSuccess/MarkInstanceFail/ReleaseInstance结束1次
 	SelectInstance(options *SelectInstanceOptions) (instance *ServiceInstance, err error)
 
 	// 节点"正常+1"
@@ -63,4 +73,10 @@ type IServiceDiscovery interface {
 
 	// 节点"异常+1"
 	MarkInstanceFail(options *MarkInstanceOptions)
+
+	// 节点请求结束但不计成败（如客户端提前离开）
+	ReleaseInstance(options *MarkInstanceOptions)
+
+	// 监听节点下线（从注册中心消失、熔断、主动探测失败），用于淘汰连接等资源
+	OnInstanceDown(callback func(instance *ServiceInstance))
 }
@@ -0,0 +1,293 @@
+package service_discovery
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	LB_POLICY_RANDOM          = "random"        // 按权重随机（默认）
+	LB_POLICY_ROUND_ROBIN     = "round_robin"   // 平滑加权轮询
+	LB_POLICY_LEAST_REQUEST   = "least_request" // 最少进行中请求
+	LB_POLICY_P2C             = "p2c"           // 随机二选一，比较EWMA延迟
+	LB_POLICY_CONSISTENT_HASH = "hash"          // 按请求头或客户端IP一致性哈希
+)
+
+// 负载均衡配置
+type LoadBalanceConfig struct {
+	Policy     string // 负载均衡策略
+	HashHeader string // 一致性哈希使用的请求头，为空或请求中不存在时使用客户端IP
+}
+
+// 负载均衡器，每个服务1个
+type LoadBalancer interface {
+	// 从候选节点中选择1个，候选节点非空
+	Select(instances []*NacosInstance, options *SelectInstanceOptions) (instance *NacosInstance)
+}
+
+// 按全部节点（而非候选节点）维护状态的负载均衡器，服务发现在选择前传入全部节点
+type instanceSetAware interface {
+	setInstances(instances []*NacosInstance)
+}
+
+// 检查策略名
+func checkLoadBalanceConfig(config *LoadBalanceConfig) (err error) {
+	switch config.Policy {
+	case "", LB_POLICY_RANDOM, LB_POLICY_ROUND_ROBIN, LB_POLICY_LEAST_REQUEST, LB_POLICY_P2C, LB_POLICY_CONSISTENT_HASH:
+	default:
+		err = fmt.Errorf("不支持的负载均衡策略: %s", config.Policy)
+	}
+	return
+}
+
+// 新建负载均衡器
+func newLoadBalancer(config *LoadBalanceConfig) (loadBalancer LoadBalancer) {
+	switch config.Policy {
+	case LB_POLICY_ROUND_ROBIN:
+		loadBalancer = &roundRobinLoadBalancer{currentWeights: make(map[string]float64)}
+	case LB_POLICY_LEAST_REQUEST:
+		loadBalancer = &leastRequestLoadBalancer{}
+	case LB_POLICY_P2C:
+		loadBalancer = &p2cLoadBalancer{}
+	case LB_POLICY_CONSISTENT_HASH:
+		loadBalancer = &consistentHashLoadBalancer{hashHeader: config.HashHeader}
+	default:
+		loadBalancer = &randomLoadBalancer{}
+	}
+	return
+}
+
+// 节点的负载统计，实例列表更新时迁移到新实例对象
+type instanceStats struct {
+	outstanding int64 // 进行中请求数（atomic）
+
+	mu         sync.Mutex
+	ewma       float64   // 延迟EWMA（纳秒）
+	lastUpdate time.Time // 最近一次更新EWMA的时间
+}
+
+// EWMA衰减周期
+const ewmaDecay = float64(10 * time.Second)
+
+// 请求开始
+func (stats *instanceStats) begin() {
+	atomic.AddInt64(&stats.outstanding, 1)
+}
+
+// 请求结束，latency<=0表示不参与延迟统计
+func (stats *instanceStats) end(latency time.Duration) {
+	if atomic.AddInt64(&stats.outstanding, -1) < 0 {
+		atomic.StoreInt64(&stats.outstanding, 0)
+	}
+	if latency <= 0 {
+		return
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	now := time.Now()
+	if stats.lastUpdate.IsZero() {
+		stats.ewma = float64(latency)
+	} else { // 距离上次更新越久，旧值权重越低
+		w := math.Exp(-float64(now.Sub(stats.lastUpdate)) / ewmaDecay)
+		stats.ewma = stats.ewma*w + float64(latency)*(1-w)
+	}
+	stats.lastUpdate = now
+}
+
+func (stats *instanceStats) getOutstanding() int64 {
+	return atomic.LoadInt64(&stats.outstanding)
+}
+
+func (stats *instanceStats) getEwma() float64 {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.ewma
+}
+
+// 按权重随机
+type randomLoadBalancer struct{}
+
+func (lb *randomLoadBalancer) Select(instances []*NacosInstance, options *SelectInstanceOptions) (instance *NacosInstance) {
+	instance = weightedRandom(instances)
+	return
+}
+
+// 平滑加权轮询（同nginx）
+type roundRobinLoadBalancer struct {
+	mu             sync.Mutex
+	currentWeights map[string]float64 // 节点ID -> 当前权重
+}
+
+func (lb *roundRobinLoadBalancer) Select(instances []*NacosInstance, options *SelectInstanceOptions) (instance *NacosInstance) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	// 清理已下线节点
+	if len(lb.currentWeights) > len(instances) {
+		alive := make(map[string]float64, len(instances))
+		for _, ins := range instances {
+			alive[ins.id] = lb.currentWeights[ins.id]
+		}
+		lb.currentWeights = alive
+	}
+
+	totalWeight := 0.0
+	for _, ins := range instances {
+		weight := ins.weight
+		if weight <= 0 {
+			continue
+		}
+		totalWeight += weight
+		lb.currentWeights[ins.id] += weight
+		if instance == nil || lb.currentWeights[ins.id] > lb.currentWeights[instance.id] {
+			instance = ins
+		}
+	}
+	if instance == nil { // 全部权重<=0
+		instance = weightedRandom(instances)
+		return
+	}
+	lb.currentWeights[instance.id] -= totalWeight
+	return
+}
+
+// 最少进行中请求，按权重折算
+type leastRequestLoadBalancer struct{}
+
+func (lb *leastRequestLoadBalancer) Select(instances []*NacosInstance, options *SelectInstanceOptions) (instance *NacosInstance) {
+	var best []*NacosInstance
+	bestScore := math.MaxFloat64
+	for _, ins := range instances {
+		weight := ins.weight
+		if weight <= 0 {
+			weight = 1
+		}
+		score := float64(ins.stats.getOutstanding()+1) / weight
+		if score < bestScore {
+			bestScore = score
+			best = best[:0]
+			best = append(best, ins)
+		} else if score == bestScore {
+			best = append(best, ins)
+		}
+	}
+	// 并列时随机，避免总是打到第1个
+	instance = best[rand.Intn(len(best))]
+	return
+}
+
+// 随机二选一，选EWMA延迟*(进行中请求+1)更小的
+type p2cLoadBalancer struct{}
+
+func (lb *p2cLoadBalancer) Select(instances []*NacosInstance, options *SelectInstanceOptions) (instance *NacosInstance) {
+	if len(instances) == 1 {
+		instance = instances[0]
+		return
+	}
+	i := rand.Intn(len(instances))
+	j := rand.Intn(len(instances) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := instances[i], instances[j]
+	if p2cScore(a) <= p2cScore(b) {
+		instance = a
+	} else {
+		instance = b
+	}
+	return
+}
+
+func p2cScore(ins *NacosInstance) float64 {
+	return ins.stats.getEwma() * float64(ins.stats.getOutstanding()+1)
+}
+
+// 一致性哈希环上的虚拟节点数
+const hashVirtualNodes = 160
+
+type hashRingNode struct {
+	hash uint32
+	id   string // 节点ID
+}
+
+// 一致性哈希
+type consistentHashLoadBalancer struct {
+	hashHeader string
+
+	mu        sync.Mutex
+	signature string // 构建哈希环时的节点集合，变化时重建
+	ring      []hashRingNode
+}
+
+func (lb *consistentHashLoadBalancer) Select(instances []*NacosInstance, options *SelectInstanceOptions) (instance *NacosInstance) {
+	// 哈希key：请求头优先，其次客户端IP
+	key := ""
+	if lb.hashHeader != "" && options.Header != nil {
+		key = options.Header.Get(lb.hashHeader)
+	}
+	if key == "" {
+		key = options.ClientIp
+	}
+	if key == "" { // 没有key，退化为按权重随机
+		instance = weightedRandom(instances)
+		return
+	}
+
+	candidates := make(map[string]*NacosInstance, len(instances))
+	for _, ins := range instances {
+		candidates[ins.id] = ins
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	// 沿环顺时针找到第1个候选节点，跳过熔断、不健康、已尝试过的节点
+	hash := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(lb.ring), func(i int) bool { return lb.ring[i].hash >= hash })
+	for i := 0; i < len(lb.ring); i++ {
+		if instance = candidates[lb.ring[(idx+i)%len(lb.ring)].id]; instance != nil {
+			return
+		}
+	}
+	// 候选节点不在环上（实例列表刚刚更新），按权重随机
+	instance = weightedRandom(instances)
+	return
+}
+
+// 按全部节点构建哈希环，节点集合变化时才重建，候选节点变化（熔断、重试避开）不影响环
+func (lb *consistentHashLoadBalancer) setInstances(instances []*NacosInstance) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	ids := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		ids = append(ids, ins.id)
+	}
+	sort.Strings(ids)
+	signature := strings.Join(ids, ",")
+	if signature == lb.signature {
+		return
+	}
+
+	ring := make([]hashRingNode, 0, len(ids)*hashVirtualNodes)
+	for _, id := range ids {
+		for i := 0; i < hashVirtualNodes; i++ {
+			ring = append(ring, hashRingNode{
+				hash: crc32.ChecksumIEEE([]byte(id + "#" + strconv.Itoa(i))),
+				id:   id,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	lb.ring = ring
+	lb.signature = signature
+}
@@ -3,7 +3,9 @@ package service_discovery
 import (
 	"errors"
 	"math/rand"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/owenliang/nacos-reverse-proxy/breaker"
@@ -24,12 +26,15 @@ type NacosInstance struct {
 	cluster string
 	service *NacosService
 	breaker *breaker.Breaker // 熔断器
+	stats   *instanceStats   // 负载统计
+	health  *instanceHealth  // 主动探测状态
 }
 
 const (
-	NACOS_SERVICE_STATUS_NOT_INIT = 0 // 未初始化
-	NACOS_SERVICE_STATUS_LOADING  = 1 // 初次加载中
-	NACOS_SERVICE_STATUS_RUNNING  = 2 // 正常服务中
+	NACOS_SERVICE_STATUS_NOT_INIT  = 0 // 未初始化
+	NACOS_SERVICE_STATUS_LOADING   = 1 // 初次加载中
+	NACOS_SERVICE_STATUS_RUNNING   = 2 // 正常服务中
+	NACOS_SERVICE_STATUS_NOT_FOUND = 3 // nacos中不存在（负缓存），直接走DNS
 )
 
 // Nacos服务
@@ -41,10 +46,16 @@ type NacosService struct {
 	instanceMapping map[string]*NacosInstance
 	status          int
 	nsd             *NacosServiceDiscovery
+	loadBalancer    LoadBalancer
+	notFoundTime    time.Time // 最近一次确认不存在的时间
+	healthChecking  bool      // 主动健康检查协程已启动
+	stopNotify      chan byte // 被淘汰时关闭，通知后台协程退出
+	stale           bool      // 正在使用过期数据（来自快照或nacos不可用）
+	lastAccessTime  int64     // 最近一次服务发现的时间（atomic，unix纳秒）
 }
 
 // instance成功率统计
-func (nacosService *NacosService) markInstance(id string, success bool) {
+func (nacosService *NacosService) markInstance(id string, success bool, latency time.Duration) {
 	nacosService.mu.Lock()
 	instanceMapping := nacosService.instanceMapping
 	nacosService.mu.Unlock()
@@ -54,11 +65,25 @@ func (nacosService *NacosService) markInstance(id string, success bool) {
 		return
 	}
 
+	// 请求结束，更新负载统计
+	instance.stats.end(latency)
+
 	// 给熔断器更新计数
 	if success {
 		instance.breaker.RecordSuccess()
-	} else {
-		instance.breaker.RecordFail()
+	} else if instance.breaker.RecordFail() { // 触发熔断
+		nacosService.nsd.notifyInstanceDown(instance)
+	}
+}
+
+// instance请求结束，不计成败
+func (nacosService *NacosService) releaseInstance(id string) {
+	nacosService.mu.Lock()
+	instanceMapping := nacosService.instanceMapping
+	nacosService.mu.Unlock()
+
+	if instance, exist := instanceMapping[id]; exist {
+		instance.stats.end(0)
 	}
 }
 
@@ -69,80 +94,238 @@ func (nsd *NacosServiceDiscovery) newNacosService(serviceName string) (nacosServ
 	nacosService.instanceMapping = map[string]*NacosInstance{}
 	nacosService.status = NACOS_SERVICE_STATUS_NOT_INIT
 	nacosService.nsd = nsd
+	nacosService.stopNotify = make(chan byte)
+	nacosService.touch()
+	nacosService.loadBalancer = newLoadBalancer(nsd.loadBalanceConfig(serviceName))
 	return
 }
 
-func (nacosService *NacosService) syncNacosServiceForever() {
-	for {
-		// 拉hosts列表
-		instances, err := nacosService.nsd.nacosClient.SelectInstances(vo.SelectInstancesParam{
-			ServiceName: nacosService.serviceName,
-			GroupName:   nacosService.nsd.sdConfig.Group,
-			HealthyOnly: true,
-		})
-		// NACOS SDK写的太水了，根本区分不出是没有service还是调用报错。。
-		if err != nil {
-			instances = make([]model.Instance, 0)
-		}
+// 用最新的实例列表替换缓存，并落盘快照
+func (nacosService *NacosService) updateInstances(instances []model.Instance) {
+	replaced, removed := nacosService.replaceInstances(instances)
+	for _, ins := range removed { // 已从nacos消失的节点
+		nacosService.nsd.notifyInstanceDown(ins)
+	}
+	if !replaced {
+		return
+	}
 
-		// 生成host mapping
-		instanceMapping := make(map[string]*NacosInstance)
-		for _, ins := range instances {
-			instanceMapping[ins.InstanceId] = &NacosInstance{
-				id:      ins.InstanceId,
-				ip:      ins.Ip,
-				port:    ins.Port,
-				weight:  ins.Weight,
-				cluster: ins.ClusterName,
-				service: nacosService,
+	// 拿到了nacos的最新数据
+	nacosService.setStale(false)
+	if nacosService.nsd.sdConfig.SnapshotDir != "" {
+		nacosService.nsd.saveSnapshot(nacosService.snapshot())
+	}
+}
+
+// 新实例的熔断器、负载统计、探测状态
+func (instance *NacosInstance) initState() {
+	instance.stats = &instanceStats{}
+	instance.health = newInstanceHealth()
+	instance.breaker = breaker.NewBreaker(&breaker.Options{
+		DisonnectPeriod:      5 * time.Second,
+		RecoverySuccessTimes: 100,
+		WindowSize:           60,
+		DecideToDisconnect: func(bs []*breaker.Bucket) bool { // 熔断策略
+			fail := 0
+			success := 0
+			for _, b := range bs {
+				success += b.Success
+				fail += b.Fail
 			}
+			return fail != 0 && success != 0 && success+fail >= 5 && float64(fail) >= float64(success)*1.2
+		},
+	})
+}
+
+// 替换实例列表，迁移旧实例的状态数据，列表为空时不替换并返回false；同时返回被移除的旧实例
+func (nacosService *NacosService) replaceInstances(instances []model.Instance) (replaced bool, removed []*NacosInstance) {
+	nacosService.mu.Lock()
+	defer nacosService.mu.Unlock()
+
+	// 生成host mapping
+	instanceMapping := make(map[string]*NacosInstance)
+	for _, ins := range instances {
+		instanceMapping[ins.InstanceId] = &NacosInstance{
+			id:      ins.InstanceId,
+			ip:      ins.Ip,
+			port:    ins.Port,
+			weight:  ins.Weight,
+			cluster: ins.ClusterName,
+			service: nacosService,
 		}
+	}
 
-		// 取出现在的host mapping
-		oldInstanceMapping := nacosService.instanceMapping
-
-		// 将instance之前的状态数据迁移到新instance对象身上
-		instanceList := make([]*NacosInstance, 0, len(instanceMapping))
-		for id, ins := range instanceMapping {
-			if oldIns, exist := oldInstanceMapping[id]; exist {
-				// 拷贝之前instance的熔断器到新实例对象
-				ins.breaker = oldIns.breaker
-			} else {
-				ins.breaker = breaker.NewBreaker(&breaker.Options{
-					DisonnectPeriod:      5 * time.Second,
-					RecoverySuccessTimes: 100,
-					WindowSize:           60,
-					DecideToDisconnect: func(bs []*breaker.Bucket) bool { // 熔断策略
-						fail := 0
-						success := 0
-						for _, b := range bs {
-							success += b.Success
-							fail += b.Fail
-						}
-						return fail != 0 && success != 0 && success+fail >= 5 && float64(fail) >= float64(success)*1.2
-					},
-				})
-			}
-			instanceList = append(instanceList, ins)
+	// 取出现在的host mapping
+	oldInstanceMapping := nacosService.instanceMapping
+
+	// 将instance之前的状态数据迁移到新instance对象身上
+	instanceList := make([]*NacosInstance, 0, len(instanceMapping))
+	for id, ins := range instanceMapping {
+		if oldIns, exist := oldInstanceMapping[id]; exist {
+			// 拷贝之前instance的熔断器、负载统计、探测状态到新实例对象
+			ins.breaker = oldIns.breaker
+			ins.stats = oldIns.stats
+			ins.health = oldIns.health
+		} else {
+			ins.initState()
 		}
+		instanceList = append(instanceList, ins)
+	}
 
-		// 替换新的instance列表（todo: 优化一下，没有diff不要替换）
-		nacosService.mu.Lock()
-		if len(instanceList) > 0 { // 列表为空不覆盖旧数据，托个底
-			nacosService.instances = instanceList
-			nacosService.instanceMapping = instanceMapping
+	// 替换新的instance列表（todo: 优化一下，没有diff不要替换）
+	if len(instanceList) > 0 { // 列表为空不覆盖旧数据，托个底
+		nacosService.instances = instanceList
+		nacosService.instanceMapping = instanceMapping
+		replaced = true
+		for id, oldIns := range oldInstanceMapping {
+			if _, exist := instanceMapping[id]; !exist {
+				removed = append(removed, oldIns)
+			}
 		}
+	}
+	if len(nacosService.instances) > 0 {
 		if nacosService.status == NACOS_SERVICE_STATUS_LOADING { // 唤醒等待者
 			close(nacosService.loadNotify)
-			nacosService.status = NACOS_SERVICE_STATUS_RUNNING
 		}
+		nacosService.status = NACOS_SERVICE_STATUS_RUNNING
+	} else if nacosService.status == NACOS_SERVICE_STATUS_LOADING { // 首次加载没有实例，负缓存，唤醒等待者
+		close(nacosService.loadNotify)
+		nacosService.status = NACOS_SERVICE_STATUS_NOT_FOUND
+		nacosService.notFoundTime = time.Now()
+		nacosService.nsd.removeSnapshot(nacosService.serviceName) // 没有可恢复的快照，或nacos确认服务已不存在
+	} else if nacosService.status == NACOS_SERVICE_STATUS_NOT_FOUND { // 重新确认仍不存在
+		nacosService.notFoundTime = time.Now()
+	}
+	return
+}
+
+// 主动拉取1次hosts列表
+func (nacosService *NacosService) pullInstances() {
+	instances, err := nacosService.nsd.nacosClient.SelectInstances(vo.SelectInstancesParam{
+		ServiceName: nacosService.serviceName,
+		GroupName:   nacosService.nsd.sdConfig.Group,
+		HealthyOnly: true,
+	})
+	// NACOS SDK写的太水了，根本区分不出是没有service还是调用报错。。
+	if err != nil {
+		instances = make([]model.Instance, 0)
+		// 继续使用旧数据（可能来自快照），标记为过期
+		nacosService.mu.Lock()
+		running := nacosService.status == NACOS_SERVICE_STATUS_RUNNING
+		loading := nacosService.status == NACOS_SERVICE_STATUS_LOADING
 		nacosService.mu.Unlock()
+		if running {
+			nacosService.setStale(true)
+		} else if loading && nacosService.restoreSnapshot() { // 首次拉取失败，退回磁盘快照
+			return
+		}
+	}
+	nacosService.updateInstances(instances)
+}
 
-		// 1秒刷新1次
-		time.Sleep(1 * time.Second)
+// nacos推送变更
+func (nacosService *NacosService) onSubscribe(services []model.SubscribeService, err error) {
+	if err != nil { // hosts为空也会报错，不覆盖旧数据
+		return
+	}
+	// 与SelectInstances(HealthyOnly)保持一致的过滤规则
+	instances := make([]model.Instance, 0, len(services))
+	for _, svc := range services {
+		if !svc.Valid || !svc.Enable || svc.Weight <= 0 {
+			continue
+		}
+		instances = append(instances, model.Instance{
+			InstanceId:  svc.InstanceId,
+			Ip:          svc.Ip,
+			Port:        svc.Port,
+			Weight:      svc.Weight,
+			ClusterName: svc.ClusterName,
+		})
+	}
+	nacosService.updateInstances(instances)
+}
+
+func (nacosService *NacosService) syncNacosServiceForever() {
+	// 先拉取1次，nacos中不存在的服务（例如www.baidu.com）不订阅，协程退出
+	nacosService.pullInstances()
+	nacosService.mu.Lock()
+	notFound := nacosService.status == NACOS_SERVICE_STATUS_NOT_FOUND
+	startHealthCheck := !notFound && !nacosService.healthChecking && nacosService.nsd.sdConfig.HealthCheck.Type != ""
+	if startHealthCheck {
+		nacosService.healthChecking = true
+	}
+	nacosService.mu.Unlock()
+	if notFound {
+		return
+	}
+
+	// 开启了主动健康检查
+	if startHealthCheck {
+		go nacosService.healthCheckForever()
+	}
+
+	// 订阅变更推送
+	subscribeParam := &vo.SubscribeParam{
+		ServiceName:       nacosService.serviceName,
+		GroupName:         nacosService.nsd.sdConfig.Group,
+		SubscribeCallback: nacosService.onSubscribe,
+	}
+	// SDK在请求nacos前就登记了回调，失败时也需取消，否则回调重复登记、淘汰后仍被调用
+	subscribed := false
+	defer nacosService.nsd.nacosClient.Unsubscribe(subscribeParam)
+
+	for {
+		// 订阅失败（例如nacos不可用），取消登记，下一轮继续尝试
+		if !subscribed {
+			if subscribed = nacosService.nsd.nacosClient.Subscribe(subscribeParam) == nil; !subscribed {
+				nacosService.nsd.nacosClient.Unsubscribe(subscribeParam)
+			}
+		}
+
+		// 被淘汰则退出
+		if !nacosService.sleep(nacosService.nsd.sdConfig.ReconcileInterval) {
+			return
+		}
+
+		// 低频拉取对账，兜底推送丢失
+		nacosService.pullInstances()
+	}
+}
+
+// 等待一段时间，服务被淘汰时返回false
+func (nacosService *NacosService) sleep(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-nacosService.stopNotify:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// 淘汰服务，停止后台协程
+func (nacosService *NacosService) stop() {
+	nacosService.mu.Lock()
+	defer nacosService.mu.Unlock()
+	select {
+	case <-nacosService.stopNotify:
+	default:
+		close(nacosService.stopNotify)
+		staleServices.Delete(nacosService.serviceName)
 	}
 }
 
+// 记录访问时间
+func (nacosService *NacosService) touch() {
+	atomic.StoreInt64(&nacosService.lastAccessTime, time.Now().UnixNano())
+}
+
+// 空闲时长
+func (nacosService *NacosService) idleDuration(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&nacosService.lastAccessTime)))
+}
+
 func (nacosService *NacosService) getInstances() (instances []*NacosInstance, err error) {
 	nacosService.mu.Lock()
 	defer nacosService.mu.Unlock()
@@ -155,6 +338,12 @@ func (nacosService *NacosService) getInstances() (instances []*NacosInstance, er
 		go nacosService.syncNacosServiceForever()
 	}
 
+	// 负缓存过期，后台重新确认，本次仍然直接走DNS
+	if nacosService.status == NACOS_SERVICE_STATUS_NOT_FOUND && time.Since(nacosService.notFoundTime) >= nacosService.nsd.sdConfig.NegativeTTL {
+		nacosService.notFoundTime = time.Now()
+		go nacosService.syncNacosServiceForever()
+	}
+
 	if nacosService.status == NACOS_SERVICE_STATUS_LOADING { // 已经加载中，那么等待它完成，但限制等待时间
 		notify := nacosService.loadNotify
 		nacosService.mu.Unlock()
@@ -189,6 +378,20 @@ type NacosSDConfig struct {
 	Cluster    string
 	Group      string
 	NacosNodes []NacosNode
+
+	ReconcileInterval time.Duration // 订阅推送之外的兜底拉取间隔
+
+	LoadBalance        *LoadBalanceConfig            // 全局负载均衡配置
+	ServiceLoadBalance map[string]*LoadBalanceConfig // 服务名 -> 负载均衡配置，覆盖全局
+
+	HealthCheck *HealthCheckConfig // 主动健康检查，为nil或Type为空表示不开启
+
+	IdleTimeout time.Duration // 服务多久未被访问则淘汰
+	NegativeTTL time.Duration // nacos中不存在的服务，多久后重新确认
+	MaxServices int           // 最多缓存多少个服务
+
+	SnapshotDir       string        // 实例列表快照目录，为空表示不开启
+	SnapshotRetention time.Duration // 已淘汰服务的快照保留多久（按最后写入时间）
 }
 
 // 服务注册&发现
@@ -198,10 +401,42 @@ type NacosServiceDiscovery struct {
 
 	mu             sync.Mutex
 	serviceMapping map[string]*NacosService // 服务名 -> 服务对象
+
+	listenerMu    sync.Mutex
+	downListeners []func(instance *ServiceInstance) // 节点下线监听者
 }
 
 // 新建nacos客户端
 func NewNacosServiceDiscovery(nacosSDConfig *NacosSDConfig) (nacosServiceDiscovery *NacosServiceDiscovery, err error) {
+	if nacosSDConfig.ReconcileInterval <= 0 {
+		nacosSDConfig.ReconcileInterval = 30 * time.Second
+	}
+	if nacosSDConfig.LoadBalance == nil {
+		nacosSDConfig.LoadBalance = &LoadBalanceConfig{Policy: LB_POLICY_RANDOM}
+	}
+	if err = checkLoadBalanceConfig(nacosSDConfig.LoadBalance); err != nil {
+		return
+	}
+	for _, lbConfig := range nacosSDConfig.ServiceLoadBalance {
+		if err = checkLoadBalanceConfig(lbConfig); err != nil {
+			return
+		}
+	}
+	if nacosSDConfig.IdleTimeout <= 0 {
+		nacosSDConfig.IdleTimeout = 10 * time.Minute
+	}
+	if nacosSDConfig.NegativeTTL <= 0 {
+		nacosSDConfig.NegativeTTL = 30 * time.Second
+	}
+	if nacosSDConfig.MaxServices <= 0 {
+		nacosSDConfig.MaxServices = 1024
+	}
+	if nacosSDConfig.HealthCheck == nil {
+		nacosSDConfig.HealthCheck = &HealthCheckConfig{}
+	}
+	if err = checkHealthCheckConfig(nacosSDConfig.HealthCheck); err != nil {
+		return
+	}
 	nacosServiceDiscovery = &NacosServiceDiscovery{
 		sdConfig:       nacosSDConfig,
 		serviceMapping: make(map[string]*NacosService),
@@ -220,6 +455,83 @@ func NewNacosServiceDiscovery(nacosSDConfig *NacosSDConfig) (nacosServiceDiscove
 	if nacosServiceDiscovery.nacosClient, err = clients.NewNamingClient(vo.NacosClientParam{ClientConfig: cc, ServerConfigs: sc}); err != nil {
 		return
 	}
+
+	// 快照在服务首次拉取失败时按需读取，nacos不可用时也能服务
+	if nacosSDConfig.SnapshotDir != "" {
+		if nacosSDConfig.SnapshotRetention <= 0 {
+			nacosSDConfig.SnapshotRetention = 24 * time.Hour
+		}
+		if err = os.MkdirAll(nacosSDConfig.SnapshotDir, 0755); err != nil {
+			return
+		}
+		go nacosServiceDiscovery.sweepSnapshotsForever()
+	}
+
+	// 淘汰空闲服务
+	go nacosServiceDiscovery.evictForever()
+	return
+}
+
+// 定期淘汰空闲服务
+func (nsd *NacosServiceDiscovery) evictForever() {
+	interval := nsd.sdConfig.IdleTimeout / 2
+	if interval > 10*time.Second {
+		interval = 10 * time.Second
+	}
+	for {
+		time.Sleep(interval)
+		nsd.evictIdle()
+	}
+}
+
+func (nsd *NacosServiceDiscovery) evictIdle() {
+	now := time.Now()
+	evicted := make([]*NacosService, 0)
+
+	nsd.mu.Lock()
+	for serviceName, nacosService := range nsd.serviceMapping {
+		if nacosService.idleDuration(now) >= nsd.sdConfig.IdleTimeout {
+			delete(nsd.serviceMapping, serviceName)
+			evicted = append(evicted, nacosService)
+		}
+	}
+	nsd.mu.Unlock()
+
+	for _, nacosService := range evicted {
+		nacosService.stop()
+	}
+}
+
+// 找到服务对象，不存在则创建；缓存已满时淘汰最久未访问的服务（需持有nsd.mu）
+func (nsd *NacosServiceDiscovery) getOrCreateService(serviceName string) (nacosService *NacosService) {
+	if nacosService = nsd.serviceMapping[serviceName]; nacosService != nil {
+		return
+	}
+
+	if len(nsd.serviceMapping) >= nsd.sdConfig.MaxServices {
+		now := time.Now()
+		var lruName string
+		var lruIdle time.Duration = -1
+		for name, service := range nsd.serviceMapping {
+			if idle := service.idleDuration(now); idle > lruIdle {
+				lruName = name
+				lruIdle = idle
+			}
+		}
+		nsd.serviceMapping[lruName].stop()
+		delete(nsd.serviceMapping, lruName)
+	}
+
+	nacosService = nsd.newNacosService(serviceName)
+	nsd.serviceMapping[serviceName] = nacosService
+	return
+}
+
+// 服务的负载均衡配置
+func (nsd *NacosServiceDiscovery) loadBalanceConfig(serviceName string) (config *LoadBalanceConfig) {
+	if config = nsd.sdConfig.ServiceLoadBalance[serviceName]; config == nil {
+		config = nsd.sdConfig.LoadBalance
+	}
 	return
 }
 
@@ -268,24 +580,63 @@ func (nsd *NacosServiceDiscovery) UpdateService(options *UpdateServiceOptions) (
 	return
 }
 
+// 按nacos权重随机选择，权重<=0的节点不参与；全部<=0时退化为等概率随机
+func weightedRandom(instances []*NacosInstance) (selected *NacosInstance) {
+	totalWeight := 0.0
+	for _, ins := range instances {
+		if ins.weight > 0 {
+			totalWeight += ins.weight
+		}
+	}
+	if totalWeight <= 0 {
+		selected = instances[rand.Intn(len(instances))]
+		return
+	}
+
+	point := rand.Float64() * totalWeight
+	for _, ins := range instances {
+		if ins.weight <= 0 {
+			continue
+		}
+		selected = ins
+		if point < ins.weight {
+			break
+		}
+		point -= ins.weight
+	}
+	return
+}
+
 // 服务发现节点
 func (nsd *NacosServiceDiscovery) SelectInstance(options *SelectInstanceOptions) (instance *ServiceInstance, err error) {
 	// 找到nacosService
 	nsd.mu.Lock()
-	nacosService, exist := nsd.serviceMapping[options.ServiceName]
-	if !exist {
-		nacosService = nsd.newNacosService(options.ServiceName)
-		nsd.serviceMapping[options.ServiceName] = nacosService
-	}
+	nacosService := nsd.getOrCreateService(options.ServiceName)
 	nsd.mu.Unlock()
+	nacosService.touch()
 
 	// 获取实例列表
 	instances, err := nacosService.getInstances()
 	if err == nil && len(instances) > 0 {
-		// 挑出候选节点
+		if aware, ok := nacosService.loadBalancer.(instanceSetAware); ok {
+			aware.setInstances(instances)
+		}
+		// 避开已尝试过的节点，都尝试过了则不再避开
+		if len(options.ExcludeIDs) > 0 {
+			remainInstances := make([]*NacosInstance, 0, len(instances))
+			for _, ins := range instances {
+				if !options.ExcludeIDs[ins.id] {
+					remainInstances = append(remainInstances, ins)
+				}
+			}
+			if len(remainInstances) > 0 {
+				instances = remainInstances
+			}
+		}
+		// 挑出候选节点：未熔断且主动探测健康
 		candidateInstances := make([]*NacosInstance, 0, len(instances))
 		for _, ins := range instances {
-			if ins.breaker.Ok() {
+			if ins.breaker.Ok() && ins.health.isHealthy() {
 				candidateInstances = append(candidateInstances, ins)
 			}
 		}
@@ -293,13 +644,14 @@ func (nsd *NacosServiceDiscovery) SelectInstance(options *SelectInstanceOptions)
 		if len(candidateInstances) == 0 {
 			candidateInstances = instances
 		}
-		// 随机选一个返回
-		idx := rand.Int() % len(candidateInstances)
+		// 由服务的负载均衡器选一个返回
+		selected := nacosService.loadBalancer.Select(candidateInstances, options)
+		selected.stats.begin()
 		instance = &ServiceInstance{
 			ServiceName: options.ServiceName,
-			ID:          instances[idx].id,
-			Ip:          instances[idx].ip,
-			Port:        instances[idx].port,
+			ID:          selected.id,
+			Ip:          selected.ip,
+			Port:        selected.port,
 		}
 	} else {
 		err = errors.New("没有可用instance")
@@ -315,7 +667,7 @@ func (nsd *NacosServiceDiscovery) MarkInstanceSuccess(options *MarkInstanceOptio
 	if !exist {
 		return
 	}
-	service.markInstance(options.ID, true)
+	service.markInstance(options.ID, true, options.Latency)
 }
 
 // 节点"异常+1"
@@ -326,5 +678,40 @@ func (nsd *NacosServiceDiscovery) MarkInstanceFail(options *MarkInstanceOptions)
 	if !exist {
 		return
 	}
-	service.markInstance(options.ID, false)
+	service.markInstance(options.ID, false, options.Latency)
+}
+
+// 节点请求结束，不计成败
+func (nsd *NacosServiceDiscovery) ReleaseInstance(options *MarkInstanceOptions) {
+	nsd.mu.Lock()
+	service, exist := nsd.serviceMapping[options.ServiceName]
+	nsd.mu.Unlock()
+	if !exist {
+		return
+	}
+	service.releaseInstance(options.ID)
+}
+
+// 监听节点下线
+func (nsd *NacosServiceDiscovery) OnInstanceDown(callback func(instance *ServiceInstance)) {
+	nsd.listenerMu.Lock()
+	defer nsd.listenerMu.Unlock()
+	nsd.downListeners = append(nsd.downListeners, callback)
+}
+
+// 通知节点下线
+func (nsd *NacosServiceDiscovery) notifyInstanceDown(instance *NacosInstance) {
+	nsd.listenerMu.Lock()
+	listeners := nsd.downListeners
+	nsd.listenerMu.Unlock()
+
+	serviceInstance := &ServiceInstance{
+		ServiceName: instance.service.serviceName,
+		ID:          instance.id,
+		Ip:          instance.ip,
+		Port:        instance.port,
+	}
+	for _, listener := range listeners {
+		listener(serviceInstance)
+	}
 }
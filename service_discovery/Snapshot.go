@@ -0,0 +1,204 @@
+package service_discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	staleServices       = expvar.NewMap("nacos_stale_services")        // 服务名 -> 是否正在使用过期数据(1/0)
+	snapshotWriteErrors = expvar.NewInt("nacos_snapshot_write_errors") // 快照写入失败次数
+)
+
+// 快照中的实例
+type snapshotInstance struct {
+	ID      string  `json:"id"`
+	Ip      string  `json:"ip"`
+	Port    uint64  `json:"port"`
+	Weight  float64 `json:"weight"`
+	Cluster string  `json:"cluster"`
+}
+
+// 服务的实例列表快照
+type serviceSnapshot struct {
+	ServiceName string             `json:"service_name"`
+	UpdateTime  time.Time          `json:"update_time"`
+	Instances   []snapshotInstance `json:"instances"`
+}
+
+// 快照文件路径
+func (nsd *NacosServiceDiscovery) snapshotPath(serviceName string) string {
+	return filepath.Join(nsd.sdConfig.SnapshotDir, url.PathEscape(serviceName)+".json")
+}
+
+// 写快照：先写临时文件再rename，保证原子性
+func (nsd *NacosServiceDiscovery) saveSnapshot(snapshot *serviceSnapshot) (err error) {
+	defer func() {
+		if err != nil {
+			snapshotWriteErrors.Add(1)
+		}
+	}()
+
+	var data []byte
+	if data, err = json.Marshal(snapshot); err != nil {
+		return
+	}
+	var tmpFile *os.File
+	if tmpFile, err = ioutil.TempFile(nsd.sdConfig.SnapshotDir, ".snapshot-*"); err != nil {
+		return
+	}
+	defer os.Remove(tmpFile.Name()) // rename成功后删除会失败，无影响
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return
+	}
+	if err = tmpFile.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tmpFile.Name(), nsd.snapshotPath(snapshot.ServiceName))
+	return
+}
+
+// 删除服务的快照（nacos确认服务不存在）
+func (nsd *NacosServiceDiscovery) removeSnapshot(serviceName string) {
+	if nsd.sdConfig.SnapshotDir == "" {
+		return
+	}
+	os.Remove(nsd.snapshotPath(serviceName))
+}
+
+// 定期清理快照：已淘汰的服务不再刷新快照，超过保留时间（按修改时间）后删除
+func (nsd *NacosServiceDiscovery) sweepSnapshotsForever() {
+	for {
+		time.Sleep(time.Minute)
+		nsd.sweepSnapshots()
+	}
+}
+
+// 执行1次快照清理，缓存中的服务不清理
+func (nsd *NacosServiceDiscovery) sweepSnapshots() {
+	files, err := ioutil.ReadDir(nsd.sdConfig.SnapshotDir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") || now.Sub(file.ModTime()) < nsd.sdConfig.SnapshotRetention {
+			continue
+		}
+		serviceName, err := url.PathUnescape(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		// 持有锁，避免删除期间服务被重新访问
+		nsd.mu.Lock()
+		if _, cached := nsd.serviceMapping[serviceName]; !cached {
+			os.Remove(filepath.Join(nsd.sdConfig.SnapshotDir, file.Name()))
+		}
+		nsd.mu.Unlock()
+	}
+}
+
+// 读取1个快照文件
+func readSnapshot(path string) (snapshot *serviceSnapshot, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	snapshot = &serviceSnapshot{}
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		return
+	}
+	if snapshot.ServiceName == "" || len(snapshot.Instances) == 0 {
+		err = errors.New("快照为空")
+	}
+	return
+}
+
+// 首次拉取失败（如nacos维护期间服务被淘汰后再次访问），从磁盘快照恢复，成功返回true
+func (nacosService *NacosService) restoreSnapshot() bool {
+	if nacosService.nsd.sdConfig.SnapshotDir == "" {
+		return false
+	}
+	snapshot, err := readSnapshot(nacosService.nsd.snapshotPath(nacosService.serviceName))
+	if err != nil || snapshot.ServiceName != nacosService.serviceName {
+		return false
+	}
+	nacosService.loadSnapshot(snapshot)
+	return true
+}
+
+// 用快照数据初始化服务，标记为过期数据，后台继续向nacos同步
+func (nacosService *NacosService) loadSnapshot(snapshot *serviceSnapshot) {
+	instances := make([]*NacosInstance, 0, len(snapshot.Instances))
+	instanceMapping := make(map[string]*NacosInstance)
+	for _, ins := range snapshot.Instances {
+		instance := &NacosInstance{
+			id:      ins.ID,
+			ip:      ins.Ip,
+			port:    ins.Port,
+			weight:  ins.Weight,
+			cluster: ins.Cluster,
+			service: nacosService,
+		}
+		instance.initState()
+		instances = append(instances, instance)
+		instanceMapping[instance.id] = instance
+	}
+
+	nacosService.mu.Lock()
+	nacosService.instances = instances
+	nacosService.instanceMapping = instanceMapping
+	if nacosService.status == NACOS_SERVICE_STATUS_LOADING { // 唤醒等待者
+		close(nacosService.loadNotify)
+	}
+	nacosService.status = NACOS_SERVICE_STATUS_RUNNING
+	nacosService.mu.Unlock()
+	nacosService.setStale(true)
+}
+
+// 生成当前实例列表的快照
+func (nacosService *NacosService) snapshot() (snapshot *serviceSnapshot) {
+	nacosService.mu.Lock()
+	defer nacosService.mu.Unlock()
+
+	snapshot = &serviceSnapshot{
+		ServiceName: nacosService.serviceName,
+		UpdateTime:  time.Now(),
+		Instances:   make([]snapshotInstance, 0, len(nacosService.instances)),
+	}
+	for _, ins := range nacosService.instances {
+		snapshot.Instances = append(snapshot.Instances, snapshotInstance{
+			ID:      ins.id,
+			Ip:      ins.ip,
+			Port:    ins.port,
+			Weight:  ins.weight,
+			Cluster: ins.cluster,
+		})
+	}
+	return
+}
+
+// 标记是否正在使用过期数据
+func (nacosService *NacosService) setStale(stale bool) {
+	nacosService.mu.Lock()
+	nacosService.stale = stale
+	nacosService.mu.Unlock()
+
+	value := &expvar.Int{}
+	if stale {
+		value.Set(1)
+	}
+	staleServices.Set(nacosService.serviceName, value)
+}
@@ -0,0 +1,151 @@
+package service_discovery
+
+import (
+	"sort"
+	"time"
+
+	"github.com/owenliang/nacos-reverse-proxy/breaker"
+)
+
+// 服务加载状态名
+var serviceStatusNames = map[int]string{
+	NACOS_SERVICE_STATUS_NOT_INIT:  "NOT_INIT",
+	NACOS_SERVICE_STATUS_LOADING:   "LOADING",
+	NACOS_SERVICE_STATUS_RUNNING:   "RUNNING",
+	NACOS_SERVICE_STATUS_NOT_FOUND: "NOT_FOUND",
+}
+
+// 熔断状态名
+var breakerStatusNames = map[int]string{
+	breaker.BREAKER_STATUS_CONNECT:      "CONNECT",
+	breaker.BREAKER_STATUS_DISCONNECT:   "DISCONNECT",
+	breaker.BREAKER_STATUS_HALF_CONNECT: "HALF_CONNECT",
+}
+
+// 节点熔断器状态
+type BreakerStatus struct {
+	Status     int             `json:"status"`
+	StatusName string          `json:"status_name"`
+	Buckets    []*BucketStatus `json:"buckets"` // 最近N秒的统计，最后1个为当前秒
+}
+
+// 熔断器1秒的统计
+type BucketStatus struct {
+	Success int `json:"success"`
+	Fail    int `json:"fail"`
+}
+
+// 节点主动探测状态
+type HealthStatus struct {
+	Healthy   bool      `json:"healthy"`
+	LastProbe time.Time `json:"last_probe"`
+	LastError string    `json:"last_error"`
+}
+
+// 节点状态
+type InstanceStatus struct {
+	ID          string         `json:"id"`
+	Ip          string         `json:"ip"`
+	Port        uint64         `json:"port"`
+	Weight      float64        `json:"weight"`
+	Cluster     string         `json:"cluster"`
+	Outstanding int64          `json:"outstanding"` // 进行中请求数
+	EwmaMs      float64        `json:"ewma_ms"`     // 延迟EWMA
+	Breaker     *BreakerStatus `json:"breaker"`
+	Health      *HealthStatus  `json:"health"`
+}
+
+// 服务状态
+type ServiceStatus struct {
+	ServiceName string            `json:"service_name"`
+	Status      int               `json:"status"`
+	StatusName  string            `json:"status_name"`
+	Stale       bool              `json:"stale"` // 正在使用过期数据
+	LoadBalance string            `json:"load_balance"`
+	Instances   []*InstanceStatus `json:"instances"`
+}
+
+func (instance *NacosInstance) getStatus() (instanceStatus *InstanceStatus) {
+	breakerState := instance.breaker.State()
+	buckets := make([]*BucketStatus, 0, len(breakerState.Buckets))
+	for _, bucket := range breakerState.Buckets {
+		buckets = append(buckets, &BucketStatus{Success: bucket.Success, Fail: bucket.Fail})
+	}
+
+	instance.health.mu.Lock()
+	healthStatus := &HealthStatus{
+		Healthy:   instance.health.healthy,
+		LastProbe: instance.health.lastProbe,
+		LastError: instance.health.lastError,
+	}
+	instance.health.mu.Unlock()
+
+	instanceStatus = &InstanceStatus{
+		ID:          instance.id,
+		Ip:          instance.ip,
+		Port:        instance.port,
+		Weight:      instance.weight,
+		Cluster:     instance.cluster,
+		Outstanding: instance.stats.getOutstanding(),
+		EwmaMs:      instance.stats.getEwma() / float64(time.Millisecond),
+		Breaker: &BreakerStatus{
+			Status:     breakerState.Status,
+			StatusName: breakerStatusNames[breakerState.Status],
+			Buckets:    buckets,
+		},
+		Health: healthStatus,
+	}
+	return
+}
+
+func (nacosService *NacosService) getStatus() (serviceStatus *ServiceStatus) {
+	nacosService.mu.Lock()
+	serviceStatus = &ServiceStatus{
+		ServiceName: nacosService.serviceName,
+		Status:      nacosService.status,
+		StatusName:  serviceStatusNames[nacosService.status],
+		Stale:       nacosService.stale,
+		LoadBalance: nacosService.nsd.loadBalanceConfig(nacosService.serviceName).Policy,
+		Instances:   make([]*InstanceStatus, 0, len(nacosService.instances)),
+	}
+	instances := nacosService.instances
+	nacosService.mu.Unlock()
+
+	for _, ins := range instances {
+		serviceStatus.Instances = append(serviceStatus.Instances, ins.getStatus())
+	}
+	sort.Slice(serviceStatus.Instances, func(i, j int) bool {
+		return serviceStatus.Instances[i].ID < serviceStatus.Instances[j].ID
+	})
+	return
+}
+
+// 全部缓存服务的状态，按服务名排序
+func (nsd *NacosServiceDiscovery) Services() (serviceStatusList []*ServiceStatus) {
+	nsd.mu.Lock()
+	services := make([]*NacosService, 0, len(nsd.serviceMapping))
+	for _, nacosService := range nsd.serviceMapping {
+		services = append(services, nacosService)
+	}
+	nsd.mu.Unlock()
+
+	serviceStatusList = make([]*ServiceStatus, 0, len(services))
+	for _, nacosService := range services {
+		serviceStatusList = append(serviceStatusList, nacosService.getStatus())
+	}
+	sort.Slice(serviceStatusList, func(i, j int) bool {
+		return serviceStatusList[i].ServiceName < serviceStatusList[j].ServiceName
+	})
+	return
+}
+
+// 单个服务的状态，不存在返回nil（不会触发加载）
+func (nsd *NacosServiceDiscovery) Service(serviceName string) (serviceStatus *ServiceStatus) {
+	nsd.mu.Lock()
+	nacosService, exist := nsd.serviceMapping[serviceName]
+	nsd.mu.Unlock()
+	if exist {
+		serviceStatus = nacosService.getStatus()
+	}
+	return
+}
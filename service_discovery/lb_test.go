@@ -0,0 +1,106 @@
+package service_discovery
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestInstances() []*NacosInstance {
+	return []*NacosInstance{
+		{id: "1", weight: 1, stats: &instanceStats{}},
+		{id: "2", weight: 2, stats: &instanceStats{}},
+		{id: "3", weight: 1, stats: &instanceStats{}},
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	lb := newLoadBalancer(&LoadBalanceConfig{Policy: LB_POLICY_ROUND_ROBIN})
+	instances := newTestInstances()
+	counter := map[string]int{}
+	for i := 0; i < 400; i++ {
+		counter[lb.Select(instances, &SelectInstanceOptions{}).id]++
+	}
+	// 严格按权重1:2:1
+	if counter["1"] != 100 || counter["2"] != 200 || counter["3"] != 100 {
+		t.Fatal(counter)
+	}
+}
+
+func TestLeastRequest(t *testing.T) {
+	lb := newLoadBalancer(&LoadBalanceConfig{Policy: LB_POLICY_LEAST_REQUEST})
+	instances := newTestInstances()
+	instances[0].stats.begin()
+	instances[1].stats.begin()
+	instances[1].stats.begin()
+	instances[1].stats.begin()
+	if lb.Select(instances, &SelectInstanceOptions{}).id != "3" {
+		t.Fatal()
+	}
+}
+
+func TestP2C(t *testing.T) {
+	lb := newLoadBalancer(&LoadBalanceConfig{Policy: LB_POLICY_P2C})
+	instances := newTestInstances()[:2]
+	instances[0].stats.begin()
+	instances[0].stats.end(100 * time.Millisecond)
+	instances[1].stats.begin()
+	instances[1].stats.end(10 * time.Millisecond)
+	if lb.Select(instances, &SelectInstanceOptions{}).id != "2" {
+		t.Fatal()
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	lb := newLoadBalancer(&LoadBalanceConfig{Policy: LB_POLICY_CONSISTENT_HASH, HashHeader: "X-User"})
+	instances := newTestInstances()
+	lb.(instanceSetAware).setInstances(instances)
+
+	// 相同key总是选中相同节点
+	header := http.Header{}
+	header.Set("X-User", "liangdong")
+	first := lb.Select(instances, &SelectInstanceOptions{Header: header, ClientIp: "1.1.1.1"}).id
+	for i := 0; i < 100; i++ {
+		if lb.Select(instances, &SelectInstanceOptions{Header: header, ClientIp: "2.2.2.2"}).id != first {
+			t.Fatal()
+		}
+	}
+
+	// 摘除其他节点不影响已映射的key
+	remain := make([]*NacosInstance, 0)
+	for _, ins := range instances {
+		if ins.id == first || len(remain) == 0 {
+			remain = append(remain, ins)
+		}
+	}
+	if lb.Select(remain, &SelectInstanceOptions{Header: header}).id != first {
+		t.Fatal()
+	}
+}
+
+func TestConsistentHashSkipCandidate(t *testing.T) {
+	lb := newLoadBalancer(&LoadBalanceConfig{Policy: LB_POLICY_CONSISTENT_HASH})
+	instances := newTestInstances()
+	lb.(instanceSetAware).setInstances(instances)
+	signature := lb.(*consistentHashLoadBalancer).signature
+
+	mapping := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		ip := fmt.Sprintf("10.0.0.%d", i)
+		mapping[ip] = lb.Select(instances, &SelectInstanceOptions{ClientIp: ip}).id
+	}
+
+	// 节点2熔断或被重试避开：环不重建，只有映射到节点2的key改选环上的下一个节点
+	candidates := []*NacosInstance{instances[0], instances[2]}
+	lb.(instanceSetAware).setInstances(instances)
+	for ip, id := range mapping {
+		selected := lb.Select(candidates, &SelectInstanceOptions{ClientIp: ip}).id
+		if selected == "2" || id != "2" && selected != id {
+			t.Fatal(ip, id, selected)
+		}
+	}
+	if lb.(*consistentHashLoadBalancer).signature != signature {
+		t.Fatal()
+	}
+}
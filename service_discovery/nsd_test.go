@@ -1,9 +1,16 @@
 package service_discovery
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/nacos-group/nacos-sdk-go/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/model"
+	"github.com/nacos-group/nacos-sdk-go/vo"
 )
 
 func TestRegister(t *testing.T) {
@@ -75,3 +82,317 @@ func TestDiscovery(t *testing.T) {
 		time.Sleep(35 * time.Second)
 	}
 }
+
+func TestSubscribeUpdate(t *testing.T) {
+	nsd := &NacosServiceDiscovery{sdConfig: &NacosSDConfig{LoadBalance: &LoadBalanceConfig{}}, serviceMapping: make(map[string]*NacosService)}
+	nacosService := nsd.newNacosService("a.yuerblog.cc")
+	nacosService.status = NACOS_SERVICE_STATUS_LOADING
+	nacosService.loadNotify = make(chan byte)
+
+	// 首次推送，过滤不健康/禁用/0权重实例
+	nacosService.onSubscribe([]model.SubscribeService{
+		{InstanceId: "1", Ip: "10.0.0.1", Port: 80, Weight: 1, Valid: true, Enable: true},
+		{InstanceId: "2", Ip: "10.0.0.2", Port: 80, Weight: 1, Valid: false, Enable: true},
+		{InstanceId: "3", Ip: "10.0.0.3", Port: 80, Weight: 1, Valid: true, Enable: false},
+		{InstanceId: "4", Ip: "10.0.0.4", Port: 80, Weight: 0, Valid: true, Enable: true},
+	}, nil)
+	if nacosService.status != NACOS_SERVICE_STATUS_RUNNING || len(nacosService.instances) != 1 {
+		t.Fatal()
+	}
+	oldBreaker := nacosService.instanceMapping["1"].breaker
+
+	// 再次推送，熔断器迁移到新实例对象
+	nacosService.onSubscribe([]model.SubscribeService{
+		{InstanceId: "1", Ip: "10.0.0.1", Port: 80, Weight: 2, Valid: true, Enable: true},
+		{InstanceId: "5", Ip: "10.0.0.5", Port: 80, Weight: 1, Valid: true, Enable: true},
+	}, nil)
+	if len(nacosService.instances) != 2 || nacosService.instanceMapping["1"].breaker != oldBreaker {
+		t.Fatal()
+	}
+
+	// 推送为空，不覆盖旧数据
+	nacosService.onSubscribe(nil, errors.New("hosts is empty"))
+	if len(nacosService.instances) != 2 {
+		t.Fatal()
+	}
+}
+
+func TestWeightedRandom(t *testing.T) {
+	instances := []*NacosInstance{
+		{id: "1", weight: 1},
+		{id: "2", weight: 3},
+		{id: "3", weight: 0},
+	}
+	counter := map[string]int{}
+	for i := 0; i < 40000; i++ {
+		counter[weightedRandom(instances).id]++
+	}
+	// 权重0不选中，1:3分布
+	if counter["3"] != 0 || counter["2"] < counter["1"]*2 || counter["2"] > counter["1"]*4 {
+		t.Fatal(counter)
+	}
+}
+
+func TestInstanceHealth(t *testing.T) {
+	config := &HealthCheckConfig{Type: "tcp", SuccessThreshold: 2, FailThreshold: 2}
+	health := newInstanceHealth()
+
+	health.record(errors.New("refused"), config)
+	if !health.isHealthy() {
+		t.Fatal()
+	}
+	health.record(errors.New("refused"), config)
+	if health.isHealthy() {
+		t.Fatal()
+	}
+	health.record(nil, config)
+	if health.isHealthy() {
+		t.Fatal()
+	}
+	health.record(nil, config)
+	if !health.isHealthy() {
+		t.Fatal()
+	}
+
+	// 探测器各轮复用，地址变化时新建
+	prober, _ := health.proberOf("127.0.0.1:80", config)
+	if same, _ := health.proberOf("127.0.0.1:80", config); same != prober {
+		t.Fatal()
+	}
+	if other, _ := health.proberOf("127.0.0.1:81", config); other == prober {
+		t.Fatal()
+	}
+}
+
+func TestServiceEviction(t *testing.T) {
+	nsd := &NacosServiceDiscovery{
+		sdConfig:       &NacosSDConfig{LoadBalance: &LoadBalanceConfig{}, IdleTimeout: time.Hour, MaxServices: 2},
+		serviceMapping: make(map[string]*NacosService),
+	}
+
+	// 超过上限，淘汰最久未访问的
+	a := nsd.getOrCreateService("a")
+	time.Sleep(time.Millisecond)
+	nsd.getOrCreateService("b")
+	nsd.getOrCreateService("c")
+	if _, exist := nsd.serviceMapping["a"]; exist || len(nsd.serviceMapping) != 2 {
+		t.Fatal()
+	}
+	select {
+	case <-a.stopNotify: // 后台协程被通知退出
+	default:
+		t.Fatal()
+	}
+
+	// 空闲淘汰
+	nsd.sdConfig.IdleTimeout = time.Nanosecond
+	nsd.evictIdle()
+	if len(nsd.serviceMapping) != 0 {
+		t.Fatal()
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	// 拿到nacos数据后落盘快照
+	writer := &NacosServiceDiscovery{
+		sdConfig:       &NacosSDConfig{LoadBalance: &LoadBalanceConfig{}, SnapshotDir: dir},
+		serviceMapping: make(map[string]*NacosService),
+	}
+	nacosService := writer.newNacosService("a.yuerblog.cc")
+	nacosService.status = NACOS_SERVICE_STATUS_LOADING
+	nacosService.loadNotify = make(chan byte)
+	nacosService.updateInstances([]model.Instance{{InstanceId: "1", Ip: "10.0.0.1", Port: 80, Weight: 1}})
+
+	// nacos不可用，首次访问时从快照恢复
+	nsd, err := NewNacosServiceDiscovery(&NacosSDConfig{
+		Namespace:   "myns",
+		Cluster:     "main",
+		Group:       "default",
+		NacosNodes:  []NacosNode{{"127.0.0.1", 1}},
+		SnapshotDir: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err := nsd.SelectInstance(&SelectInstanceOptions{ServiceName: "a.yuerblog.cc"})
+	if err != nil || ins.Ip != "10.0.0.1" || ins.Port != 80 {
+		t.Fatal(ins, err)
+	}
+	restored := nsd.serviceMapping["a.yuerblog.cc"]
+	restored.mu.Lock()
+	defer restored.mu.Unlock()
+	if !restored.stale {
+		t.Fatal()
+	}
+}
+
+// 模拟SDK：订阅先登记回调再请求nacos，取消订阅移除该回调的全部登记
+type fakeNamingClient struct {
+	naming_client.INamingClient
+	mu            sync.Mutex
+	selectErr     error // 拉取实例列表的错误
+	notFound      bool  // nacos中不存在服务，拉取到空列表
+	subscribeErrs int   // 前几次订阅失败
+	subscribes    int
+	callbacks     int // 当前登记的回调数
+	maxCallbacks  int
+}
+
+func (client *fakeNamingClient) SelectInstances(param vo.SelectInstancesParam) ([]model.Instance, error) {
+	if client.selectErr != nil {
+		return nil, client.selectErr
+	}
+	if client.notFound {
+		return []model.Instance{}, nil
+	}
+	return []model.Instance{{InstanceId: "1", Ip: "10.0.0.1", Port: 80, Weight: 1}}, nil
+}
+
+func (client *fakeNamingClient) Subscribe(param *vo.SubscribeParam) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.subscribes++
+	client.callbacks++
+	if client.callbacks > client.maxCallbacks {
+		client.maxCallbacks = client.callbacks
+	}
+	if client.subscribes <= client.subscribeErrs {
+		return errors.New("nacos不可用")
+	}
+	return nil
+}
+
+func (client *fakeNamingClient) Unsubscribe(param *vo.SubscribeParam) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.callbacks = 0
+	return nil
+}
+
+func TestSubscribeRetry(t *testing.T) {
+	client := &fakeNamingClient{subscribeErrs: 3}
+	nsd := &NacosServiceDiscovery{
+		sdConfig:       &NacosSDConfig{LoadBalance: &LoadBalanceConfig{}, HealthCheck: &HealthCheckConfig{}, ReconcileInterval: time.Millisecond},
+		nacosClient:    client,
+		serviceMapping: make(map[string]*NacosService),
+	}
+	nacosService := nsd.newNacosService("a.yuerblog.cc")
+	nacosService.status = NACOS_SERVICE_STATUS_LOADING
+	nacosService.loadNotify = make(chan byte)
+	done := make(chan byte)
+	go func() {
+		nacosService.syncNacosServiceForever()
+		close(done)
+	}()
+
+	// 订阅失败重试期间回调不重复登记
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		client.mu.Lock()
+		subscribes := client.subscribes
+		client.mu.Unlock()
+		if subscribes > client.subscribeErrs {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(subscribes)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 淘汰后取消订阅
+	nacosService.stop()
+	<-done
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.maxCallbacks != 1 || client.callbacks != 0 {
+		t.Fatal(client.maxCallbacks, client.callbacks)
+	}
+}
+
+func TestSnapshotAfterEviction(t *testing.T) {
+	dir := t.TempDir()
+	writer := &NacosServiceDiscovery{
+		sdConfig:       &NacosSDConfig{LoadBalance: &LoadBalanceConfig{}, SnapshotDir: dir},
+		serviceMapping: make(map[string]*NacosService),
+	}
+	nacosService := writer.newNacosService("a.yuerblog.cc")
+	nacosService.status = NACOS_SERVICE_STATUS_LOADING
+	nacosService.loadNotify = make(chan byte)
+	nacosService.updateInstances([]model.Instance{{InstanceId: "1", Ip: "10.0.0.1", Port: 80, Weight: 1}})
+
+	// nacos不可用期间服务被淘汰后再次访问，从磁盘快照恢复而不是走DNS
+	nsd := &NacosServiceDiscovery{
+		sdConfig: &NacosSDConfig{
+			LoadBalance:       &LoadBalanceConfig{},
+			HealthCheck:       &HealthCheckConfig{},
+			ReconcileInterval: time.Hour,
+			MaxServices:       10,
+			SnapshotDir:       dir,
+		},
+		nacosClient:    &fakeNamingClient{selectErr: errors.New("nacos不可用")},
+		serviceMapping: make(map[string]*NacosService),
+	}
+	ins, err := nsd.SelectInstance(&SelectInstanceOptions{ServiceName: "a.yuerblog.cc"})
+	if err != nil || ins.Ip != "10.0.0.1" || ins.Port != 80 {
+		t.Fatal(ins, err)
+	}
+	restored := nsd.serviceMapping["a.yuerblog.cc"]
+	defer restored.stop()
+	restored.mu.Lock()
+	defer restored.mu.Unlock()
+	if !restored.stale {
+		t.Fatal()
+	}
+}
+
+func TestSnapshotCleanup(t *testing.T) {
+	dir := t.TempDir()
+	writer := &NacosServiceDiscovery{
+		sdConfig:       &NacosSDConfig{LoadBalance: &LoadBalanceConfig{}, SnapshotDir: dir},
+		serviceMapping: make(map[string]*NacosService),
+	}
+	for _, serviceName := range []string{"a.yuerblog.cc", "b.yuerblog.cc", "c.yuerblog.cc"} {
+		nacosService := writer.newNacosService(serviceName)
+		nacosService.status = NACOS_SERVICE_STATUS_LOADING
+		nacosService.loadNotify = make(chan byte)
+		nacosService.updateInstances([]model.Instance{{InstanceId: "1", Ip: "10.0.0.1", Port: 80, Weight: 1}})
+	}
+
+	nsd := &NacosServiceDiscovery{
+		sdConfig: &NacosSDConfig{
+			LoadBalance:       &LoadBalanceConfig{},
+			HealthCheck:       &HealthCheckConfig{},
+			ReconcileInterval: time.Hour,
+			MaxServices:       10,
+			SnapshotDir:       dir,
+			SnapshotRetention: time.Hour,
+		},
+		nacosClient:    &fakeNamingClient{notFound: true},
+		serviceMapping: make(map[string]*NacosService),
+	}
+	exists := func(serviceName string) bool {
+		_, err := os.Stat(nsd.snapshotPath(serviceName))
+		return err == nil
+	}
+
+	// nacos确认服务不存在，删除快照
+	if _, err := nsd.SelectInstance(&SelectInstanceOptions{ServiceName: "a.yuerblog.cc"}); err == nil || exists("a.yuerblog.cc") {
+		t.Fatal(err)
+	}
+
+	// 超过保留时间的快照，缓存中的服务保留，已淘汰的删除
+	expired := time.Now().Add(-2 * time.Hour)
+	for _, serviceName := range []string{"b.yuerblog.cc", "c.yuerblog.cc"} {
+		os.Chtimes(nsd.snapshotPath(serviceName), expired, expired)
+	}
+	nsd.mu.Lock()
+	nsd.getOrCreateService("c.yuerblog.cc")
+	nsd.mu.Unlock()
+	nsd.sweepSnapshots()
+	if exists("b.yuerblog.cc") || !exists("c.yuerblog.cc") {
+		t.Fatal()
+	}
+}